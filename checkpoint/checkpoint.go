@@ -0,0 +1,125 @@
+// Package checkpoint provides support for recording the progress of a scan
+// in a file, so that an interrupted scan can be resumed later without
+// repeating the probes which were already completed.
+//
+// The file is a JSON document with the following members:
+//
+//	"protocol":  the protocol which was probed ("tcp" or "udp")
+//	"saved":     the time at which the file was written (RFC 3339)
+//	"results":   an array of objects, one for each completed probe, with
+//	             the members "name" and "address" plus the members of a
+//	             port object in a report (see package report)
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/report"
+)
+
+// Entry records the result of a single completed probe.
+type Entry struct {
+	Name string `json:"name"`
+	Addr string `json:"address"`
+	report.Port
+}
+
+// State records the progress of a scan.  It is safe for concurrent use (e.g.,
+// the scan can consult it while the results are being added to it).
+type State struct {
+	Protocol string    `json:"protocol"`
+	Saved    time.Time `json:"saved"`
+	Results  []Entry   `json:"results"`
+
+	// Index of the completed probes, by address and port
+	done map[key]bool
+	// Serializes access to the record
+	mu sync.Mutex
+}
+
+// key identifies a single probe.
+type key struct {
+	addr string
+	port int
+}
+
+// New creates a new, empty record of the progress of a scan using the
+// specified protocol.
+func New(protocol string) *State {
+	return &State{
+		Protocol: protocol,
+		Results:  []Entry{},
+		done:     make(map[key]bool),
+	}
+}
+
+// Load reads the record of the progress of a scan from the specified file.
+func Load(name string) (*State, error) {
+	text, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	st := New("")
+	if err := json.Unmarshal(text, st); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	for _, e := range st.Results {
+		st.done[key{e.Addr, e.Port.Port}] = true
+	}
+	return st, nil
+}
+
+// Add records the result of the probe of the specified port on the specified
+// host.  Results which are not complete are ignored, as are repeated results.
+func (st *State) Add(name, addr string, p report.Port) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	k := key{addr, p.Port}
+	if !p.State.IsComplete() || st.done[k] {
+		return
+	}
+	st.done[k] = true
+	st.Results = append(st.Results, Entry{name, addr, p})
+}
+
+// Done returns a boolean indicating whether the probe of the specified port on
+// the specified host has been completed.
+func (st *State) Done(addr string, port int) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.done[key{addr, port}]
+}
+
+// Save writes the record of the progress of the scan to the specified file.
+// The file is replaced atomically, so that a crash during the write does not
+// lose the previous record.
+func (st *State) Save(name string) error {
+	st.mu.Lock()
+	st.Saved = time.Now()
+	text, err := json.Marshal(st)
+	st.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(text); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
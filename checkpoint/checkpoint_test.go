@@ -0,0 +1,119 @@
+// Unit tests for package checkpoint.
+package checkpoint
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/webbnh/DigitalOcean/portprobe"
+	"github.com/webbnh/DigitalOcean/report"
+)
+
+func TestAdd(t *testing.T) {
+	st := New("tcp")
+	st.Add("web", "192.0.2.1", report.NewPort("tcp", 22, portprobe.Open))
+	st.Add("web", "192.0.2.1", report.NewPort("tcp", 23, portprobe.Closed))
+	st.Add("web", "192.0.2.1", report.NewPort("tcp", 24, portprobe.Pending))
+	st.Add("web", "192.0.2.1", report.NewPort("tcp", 22, portprobe.Closed))
+
+	expected := []Entry{
+		{"web", "192.0.2.1", report.NewPort("tcp", 22, portprobe.Open)},
+		{"web", "192.0.2.1",
+			report.NewPort("tcp", 23, portprobe.Closed)},
+	}
+	if !reflect.DeepEqual(st.Results, expected) {
+		t.Errorf("Got results %v; expected %v.\n", st.Results, expected)
+	}
+
+	cases := []struct {
+		addr string
+		port int
+		done bool
+	}{
+		{"192.0.2.1", 22, true},
+		{"192.0.2.1", 23, true},
+		{"192.0.2.1", 24, false},
+		{"192.0.2.2", 22, false},
+	}
+	for _, v := range cases {
+		if got := st.Done(v.addr, v.port); got != v.done {
+			t.Errorf("Done(%s, %d) returned %v; expected %v.\n",
+				v.addr, v.port, got, v.done)
+		}
+	}
+}
+
+// Test the use of the record by several goroutines at once (which is only
+// interesting when run with the race detector).
+func TestConcurrent(t *testing.T) {
+	st := New("tcp")
+	name := filepath.Join(t.TempDir(), "scan.ckpt")
+	done := make(chan bool)
+	go func() {
+		for port := 1; port <= 100; port++ {
+			st.Add("", "192.0.2.1",
+				report.NewPort("tcp", port, portprobe.Open))
+		}
+		close(done)
+	}()
+	for port := 1; port <= 100; port++ {
+		st.Done("192.0.2.1", port)
+	}
+	if err := st.Save(name); err != nil {
+		t.Errorf("Save() returned \"%v\".\n", err)
+	}
+	<-done
+	if !st.Done("192.0.2.1", 100) {
+		t.Error("The last result was not recorded.")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "state.json")
+
+	st := New("udp")
+	p := report.NewPort("udp", 53, portprobe.Open)
+	p.Banner = `\x00\x01`
+	st.Add("db", "192.0.2.2", p)
+	p = report.NewPort("udp", 54, portprobe.Closed)
+	p.Reason, p.Attempts = portprobe.ReasonRefused, 2
+	st.Add("db", "192.0.2.2", p)
+	if err := st.Save(name); err != nil {
+		t.Fatalf("Save() returned \"%v\".\n", err)
+	}
+
+	// Saving again should replace the file.
+	st.Add("db", "192.0.2.2", report.NewPort("udp", 55, portprobe.Closed))
+	if err := st.Save(name); err != nil {
+		t.Fatalf("Save() returned \"%v\".\n", err)
+	}
+
+	got, err := Load(name)
+	if err != nil {
+		t.Fatalf("Load() returned \"%v\".\n", err)
+	}
+	if got.Protocol != "udp" || !reflect.DeepEqual(got.Results, st.Results) {
+		t.Errorf("Load() returned %+v; expected %+v.\n", got, st)
+	}
+	if !got.Done("192.0.2.2", 55) || got.Done("192.0.2.2", 56) {
+		t.Error("Load() returned an incorrect index of results.")
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(name))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Got %d files (\"%v\"); expected only the state file.\n",
+			len(entries), err)
+	}
+
+	if _, err := Load(name + ".missing"); err == nil {
+		t.Error("Load() of a missing file unexpectedly succeeded.")
+	}
+	if err := os.WriteFile(name, []byte("{\"results\": 5}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(name); err == nil {
+		t.Error("Load() of an invalid file unexpectedly succeeded.")
+	}
+}
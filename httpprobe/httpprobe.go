@@ -0,0 +1,160 @@
+// Package httpprobe provides support for fingerprinting the web server on an
+// open TCP port, by requesting its root page (over TLS or in the clear) and
+// recording the salient features of the response.
+package httpprobe
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/vdiag"
+)
+
+// Default time to allow for each request, including reading the response
+const defaultTimeout = 5 * time.Second
+
+// Maximum amount of the body of a response which is read (and hashed)
+const maxBody = 1 << 20
+
+// Maximum length of a page title, in characters
+const maxTitle = 256
+
+// Pattern which matches the title of an HTML page
+var titleRE = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// Info describes the response of a web server to a request for its root
+// page.
+type Info struct {
+	// URL which was requested (e.g., "https://192.0.2.1:443/", or
+	// "https://www.example.com:443/" for a host specified by name)
+	URL string `json:"url"`
+	// Status code of the response (e.g., 200)
+	Status int `json:"status"`
+	// Value of the Server header, if any (e.g., "nginx/1.24.0")
+	Server string `json:"server,omitempty"`
+	// Title of the page, if any
+	Title string `json:"title,omitempty"`
+	// Value of the Location header, if any (i.e., the target of a
+	// redirect)
+	Location string `json:"location,omitempty"`
+	// SHA-256 hash of the body (or of its first megabyte), in hexadecimal
+	BodyHash string `json:"body_sha256"`
+}
+
+// Prober sends HTTP requests.  The zero value is usable, and applies the
+// default timeout.
+type Prober struct {
+	// Time to allow for each request, including reading the response (0:
+	// five seconds)
+	Timeout time.Duration
+}
+
+// Probe requests the root page from the service on the specified port of the
+// specified host, first over TLS and then, if that fails, in the clear,
+// returning a description of the response, or nil if neither request
+// receives an HTTP response.  Redirects are reported rather than followed,
+// and certificates are not verified.  If the host is specified by name, the
+// name is sent to the service (for virtual hosting).
+func (p *Prober) Probe(host string, port int) *Info {
+	return p.ProbeContext(context.Background(), host, host, port)
+}
+
+// ProbeContext is like Probe, but it connects to the specified address,
+// requesting the page of the specified name (unless it is empty), in the Host
+// header and, over TLS, in the handshake, so that a service which hosts
+// several sites describes that one.  If the context is done before a response
+// is received, the requests are abandoned, and nil is returned.
+func (p *Prober) ProbeContext(ctx context.Context, name, addr string,
+	port int) *Info {
+	if name == "" {
+		name = addr
+	}
+	for _, scheme := range []string{"https", "http"} {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if info := p.get(ctx, scheme, name, addr, port); info != nil {
+			return info
+		}
+	}
+	return nil
+}
+
+// get requests the root page using the specified scheme, returning a
+// description of the response, or nil if there is none.
+func (p *Prober) get(ctx context.Context, scheme, name, addr string,
+	port int) *Info {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	// The request is addressed to the name, but the connection is made
+	// to the address.
+	address := net.JoinHostPort(addr, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: timeout}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network,
+				_ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: timeout,
+	}
+
+	url := scheme + "://" + net.JoinHostPort(name, strconv.Itoa(port)) + "/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		vdiag.Out(4, "Probe(%s) failed:  %v.\n", url, err)
+		return nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		vdiag.Out(4, "Probe(%s) failed:  %v.\n", url, err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	// A truncated body is still worth describing, so read errors are
+	// ignored.
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	hash := sha256.Sum256(body)
+	return &Info{
+		URL:      url,
+		Status:   resp.StatusCode,
+		Server:   resp.Header.Get("Server"),
+		Title:    title(body),
+		Location: resp.Header.Get("Location"),
+		BodyHash: hex.EncodeToString(hash[:]),
+	}
+}
+
+// title returns the title of the HTML page, with entities decoded and runs of
+// white space collapsed, or an empty string if it has none.
+func title(body []byte) string {
+	m := titleRE.FindSubmatch(body)
+	if m == nil {
+		return ""
+	}
+	t := strings.Join(strings.Fields(html.UnescapeString(string(m[1]))),
+		" ")
+	if r := []rune(t); len(r) > maxTitle {
+		t = string(r[:maxTitle])
+	}
+	return t
+}
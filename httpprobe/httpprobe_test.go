@@ -0,0 +1,148 @@
+// Unit tests for package httpprobe
+package httpprobe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The page served by the test servers
+const testPage = "<html><head><TITLE>\n  Welcome &amp; hello\n</TITLE>" +
+	"</head></html>"
+
+// testHandler serves the test page.
+func testHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Server", "test/1.0")
+	w.Write([]byte(testPage))
+}
+
+// port returns the port of the test server.
+func port(s *httptest.Server) int {
+	return s.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestProbe(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(testHandler))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(testHandler))
+	defer secure.Close()
+	redirect := httptest.NewServer(http.RedirectHandler("/login",
+		http.StatusMovedPermanently))
+	defer redirect.Close()
+
+	hash := sha256.Sum256([]byte(testPage))
+	pageHash := hex.EncodeToString(hash[:])
+
+	cases := []struct {
+		server   *httptest.Server
+		scheme   string
+		status   int
+		title    string
+		location string
+	}{
+		{plain, "http", 200, "Welcome & hello", ""},
+		{secure, "https", 200, "Welcome & hello", ""},
+		{redirect, "http", 301, "", "/login"},
+	}
+
+	p := Prober{Timeout: time.Second}
+	for _, v := range cases {
+		n := port(v.server)
+		info := p.Probe("127.0.0.1", n)
+		if info == nil {
+			t.Errorf("Probe(%d) returned nil.\n", n)
+			continue
+		}
+		if !strings.HasPrefix(info.URL, v.scheme+"://127.0.0.1:") ||
+			info.Status != v.status || info.Title != v.title ||
+			info.Location != v.location || len(info.BodyHash) != 64 {
+			t.Errorf("Probe(%d) returned %+v.\n", n, info)
+		}
+		if v.status == 200 && (info.Server != "test/1.0" ||
+			info.BodyHash != pageHash) {
+			t.Errorf("Probe(%d) returned %+v; expected server "+
+				"\"test/1.0\" and hash %s.\n", n, info, pageHash)
+		}
+	}
+}
+
+func TestProbeNotHTTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			conn.Close()
+		}
+	}()
+
+	p := Prober{Timeout: time.Second}
+	if info := p.Probe("127.0.0.1", l.Addr().(*net.TCPAddr).Port); info != nil {
+		t.Errorf("Probe() of a non-HTTP service returned %+v.\n", info)
+	}
+}
+
+func TestProbeName(t *testing.T) {
+	var host, serverName string
+	s := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			host = r.Host
+			if r.TLS != nil {
+				serverName = r.TLS.ServerName
+			}
+			testHandler(w, r)
+		}))
+	s.StartTLS()
+	defer s.Close()
+
+	p := Prober{Timeout: time.Second}
+	n := port(s)
+	info := p.ProbeContext(context.Background(), "www.example.com",
+		"127.0.0.1", n)
+	expected := "www.example.com:" + strconv.Itoa(n)
+	if info == nil || info.URL != "https://"+expected+"/" {
+		t.Errorf("ProbeContext() returned %+v.\n", info)
+	}
+	if host != expected || serverName != "www.example.com" {
+		t.Errorf("ProbeContext() sent host %q and server name %q; "+
+			"expected %q and \"www.example.com\".\n", host,
+			serverName, expected)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	cases := []struct {
+		body     string
+		expected string
+	}{
+		{"", ""},
+		{"<html><body>No title</body></html>", ""},
+		{"<title>Simple</title>", "Simple"},
+		{"<title lang=\"en\">\tTabs\tand\n lines </title>",
+			"Tabs and lines"},
+		{"<title>" + strings.Repeat("é", 300) + "</title>",
+			strings.Repeat("é", maxTitle)},
+	}
+
+	for _, v := range cases {
+		if got := title([]byte(v.body)); got != v.expected {
+			t.Errorf("title(%q) returned %q; expected %q.\n", v.body,
+				got, v.expected)
+		}
+	}
+}
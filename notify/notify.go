@@ -0,0 +1,142 @@
+// Package notify provides support for delivering notifications of the
+// transitions observed between successive scans (e.g., a port which was
+// opened) to the standard output, to a file, or to a webhook.
+//
+// Notifications written to the standard output or to a file are lines of
+// text, one per transition, e.g.:
+//
+//	2024-01-02T03:04:05Z web (192.0.2.1) 22/tcp (ssh): opened
+//
+// A webhook receives an HTTP POST request for each scan which produced
+// transitions, whose body is a JSON document containing an object with a
+// single member, "transitions", an array of objects, each of which has the
+// member "time" (RFC 3339) plus the members of a change object (see
+// report.Change).
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/report"
+)
+
+// Default time to allow for each webhook request
+const defaultTimeout = 10 * time.Second
+
+// Transition is a change in the results for a single port, observed at a
+// particular time.
+type Transition struct {
+	Time time.Time `json:"time"`
+	report.Change
+}
+
+// Notifier delivers notifications of transitions.
+type Notifier interface {
+	// Notify delivers a notification of the transitions observed by a
+	// single scan.
+	Notify(transitions []Transition) error
+}
+
+// New returns a Notifier for the specified destination:  "-" for the
+// standard output, an "http" or "https" URL for a webhook, or otherwise the
+// name of a file, to which notifications are appended.
+func New(dest string) Notifier {
+	switch {
+	case dest == "-":
+		return &Writer{W: os.Stdout}
+	case strings.HasPrefix(dest, "http://"),
+		strings.HasPrefix(dest, "https://"):
+		return &Webhook{URL: dest}
+	}
+	return &File{Name: dest}
+}
+
+// Writer writes notifications as lines of text.
+type Writer struct {
+	W io.Writer
+}
+
+// Notify writes a line describing each transition.
+func (n *Writer) Notify(transitions []Transition) error {
+	return writeLines(n.W, transitions)
+}
+
+// File appends notifications, as lines of text, to a file, which is created
+// if necessary.  (The file is reopened for each notification, so that it can
+// be rotated.)
+type File struct {
+	Name string
+}
+
+// Notify appends a line describing each transition to the file.
+func (n *File) Notify(transitions []Transition) error {
+	f, err := os.OpenFile(n.Name, os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		0644)
+	if err != nil {
+		return err
+	}
+	if err := writeLines(f, transitions); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Webhook posts notifications, as JSON, to a URL.  The zero value of
+// Timeout applies the default timeout.
+type Webhook struct {
+	URL string
+	// Time to allow for each request (0: ten seconds)
+	Timeout time.Duration
+}
+
+// Notify posts the transitions to the webhook.
+func (n *Webhook) Notify(transitions []Transition) error {
+	body, err := json.Marshal(struct {
+		Transitions []Transition `json:"transitions"`
+	}{transitions})
+	if err != nil {
+		return err
+	}
+
+	timeout := n.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(n.URL, "application/json",
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: unexpected response status \"%s\"", n.URL,
+			resp.Status)
+	}
+	return nil
+}
+
+// writeLines writes a line describing each transition.
+func writeLines(w io.Writer, transitions []Transition) error {
+	var buf bytes.Buffer
+	for _, t := range transitions {
+		h := report.Host{Name: t.Name, Addr: t.Addr}
+		fmt.Fprintf(&buf, "%s %v %d/%s", t.Time.Format(time.RFC3339),
+			&h, t.Port, t.Protocol)
+		if t.Service != "" {
+			fmt.Fprintf(&buf, " (%s)", t.Service)
+		}
+		fmt.Fprintf(&buf, ": %s\n", t.Description())
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,126 @@
+// Unit tests for package notify
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/report"
+)
+
+// newTransitions returns a set of transitions for testing.
+func newTransitions() []Transition {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []Transition{
+		{at, report.Change{Name: "web", Addr: "192.0.2.1", Port: 22,
+			Protocol: "tcp", Service: "ssh", Kind: report.Opened}},
+		{at, report.Change{Addr: "192.0.2.2", Port: 443,
+			Protocol: "tcp", Service: "https", Kind: report.Changed,
+			Old: "http nginx", New: "http Apache httpd"}},
+		{at, report.Change{Addr: "192.0.2.2", Port: 65001,
+			Protocol: "tcp", Kind: report.Closed}},
+	}
+}
+
+// The lines describing the test transitions
+const expectedLines = "2024-01-02T03:04:05Z web (192.0.2.1) 22/tcp (ssh): " +
+	"opened\n" +
+	"2024-01-02T03:04:05Z 192.0.2.2 443/tcp (https): service changed " +
+	"from \"http nginx\" to \"http Apache httpd\"\n" +
+	"2024-01-02T03:04:05Z 192.0.2.2 65001/tcp: closed\n"
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		dest     string
+		expected Notifier
+	}{
+		{"-", &Writer{W: os.Stdout}},
+		{"http://localhost:8080/hook",
+			&Webhook{URL: "http://localhost:8080/hook"}},
+		{"https://example.com/", &Webhook{URL: "https://example.com/"}},
+		{"watch.log", &File{Name: "watch.log"}},
+	}
+
+	for _, v := range cases {
+		if got := New(v.dest); !reflect.DeepEqual(got, v.expected) {
+			t.Errorf("New(\"%s\") returned %+v; expected %+v.\n",
+				v.dest, got, v.expected)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	n := &Writer{W: &buf}
+	if err := n.Notify(newTransitions()); err != nil {
+		t.Fatalf("Notify() returned \"%v\".\n", err)
+	}
+	if buf.String() != expectedLines {
+		t.Errorf("Got:\n%s\nexpected:\n%s\n", buf.String(), expectedLines)
+	}
+}
+
+func TestFile(t *testing.T) {
+	n := &File{Name: filepath.Join(t.TempDir(), "watch.log")}
+	transitions := newTransitions()
+	for _, v := range [][]Transition{transitions[:1], transitions[1:]} {
+		if err := n.Notify(v); err != nil {
+			t.Fatalf("Notify() returned \"%v\".\n", err)
+		}
+	}
+
+	text, err := os.ReadFile(n.Name)
+	if err != nil {
+		t.Fatalf("Unable to read notifications:  %v.\n", err)
+	}
+	if string(text) != expectedLines {
+		t.Errorf("Got:\n%s\nexpected:\n%s\n", text, expectedLines)
+	}
+
+	n.Name = t.TempDir()
+	if err := n.Notify(transitions); err == nil {
+		t.Error("Notify() to a directory unexpectedly succeeded.")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var got struct {
+		Transitions []Transition `json:"transitions"`
+	}
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost || r.Header.Get(
+				"Content-Type") != "application/json" {
+				t.Errorf("Got unexpected %s request of type "+
+					"\"%s\".\n", r.Method,
+					r.Header.Get("Content-Type"))
+			}
+			json.NewDecoder(r.Body).Decode(&got)
+			w.WriteHeader(status)
+		}))
+	defer server.Close()
+
+	n := &Webhook{URL: server.URL}
+	transitions := newTransitions()
+	if err := n.Notify(transitions); err != nil {
+		t.Fatalf("Notify() returned \"%v\".\n", err)
+	}
+	if !reflect.DeepEqual(got.Transitions, transitions) {
+		t.Errorf("Webhook received %+v; expected %+v.\n",
+			got.Transitions, transitions)
+	}
+
+	status = http.StatusInternalServerError
+	if err := n.Notify(transitions); err == nil {
+		t.Error("Notify() unexpectedly succeeded despite an error " +
+			"response.")
+	}
+}
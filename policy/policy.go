@@ -0,0 +1,313 @@
+// Package policy provides support for checking the results of a scan against
+// a policy which describes which ports are expected to be open on which
+// hosts.
+//
+// A policy is a JSON document containing an object with a single member,
+// "rules", an array of rule objects, each of which has the following members:
+//
+//	"hosts":      the hosts to which the rule applies, in the form accepted
+//	              by package targets (e.g., "10.0.0.0/24,example.com"); if
+//	              omitted, the rule applies to every host
+//	"required":   the ports which must be open, in the form accepted by
+//	              package portlist (e.g., "22,443")
+//	"allowed":    the ports which may be open, in addition to the required
+//	              ones
+//	"forbidden":  the ports which must not be open
+//	"exclusive":  if true, no ports other than the required and allowed
+//	              ones may be open
+//
+// All of the members other than "hosts" are optional.  When several rules
+// apply to a host, their effects are combined:  a port is required if any
+// rule requires it, allowed if any rule requires or allows it, and forbidden
+// if any rule forbids it, and the host's ports are exclusive if any rule
+// says so.  A host which a rule names (in its "hosts" member) but for which
+// the scan has no results violates the policy, since nothing is known about
+// its ports.  (The policy must be written in JSON; YAML is not supported.)
+// For example, the following policy says that the host 10.0.0.1 must expose
+// exactly ports 22 and 443, and that no host may expose telnet:
+//
+//	{
+//	  "rules": [
+//	    {"hosts": "10.0.0.1", "required": "22,443", "exclusive": true},
+//	    {"forbidden": "telnet"}
+//	  ]
+//	}
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/webbnh/DigitalOcean/portlist"
+	"github.com/webbnh/DigitalOcean/report"
+	"github.com/webbnh/DigitalOcean/targets"
+)
+
+// Policy is a set of rules describing the ports expected to be open.
+type Policy struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// Rule describes the ports expected to be open on a set of hosts.
+type Rule struct {
+	Hosts     string `json:"hosts,omitempty"`
+	Required  string `json:"required,omitempty"`
+	Allowed   string `json:"allowed,omitempty"`
+	Forbidden string `json:"forbidden,omitempty"`
+	Exclusive bool   `json:"exclusive,omitempty"`
+
+	// The parsed forms of the specifications (hosts and addrs are nil if
+	// the rule applies to every host)
+	hosts     []targets.Target
+	addrs     map[string]bool
+	required  []int
+	allowed   []int
+	forbidden []int
+}
+
+// Kind identifies the way in which the results for a port violate a policy.
+type Kind string
+
+// The kinds of violations
+const (
+	// A required port is not open
+	Missing Kind = "missing"
+	// A required port was not probed
+	Unprobed Kind = "unprobed"
+	// A port which is neither required nor allowed is open, and the
+	// host's ports are exclusive
+	Unexpected Kind = "unexpected"
+	// A forbidden port is open
+	Forbidden Kind = "forbidden"
+	// A host named by a rule has no results (the port is zero)
+	Unscanned Kind = "unscanned"
+)
+
+// Descriptions of the kinds of violations
+var kindText = map[Kind]string{
+	Missing:    "required port is not open",
+	Unprobed:   "required port was not probed",
+	Unexpected: "port is open but not allowed",
+	Forbidden:  "forbidden port is open",
+	Unscanned:  "host was not scanned",
+}
+
+// Violation describes the violation of a policy by the results for a single
+// port (or by the absence of results for a host).
+type Violation struct {
+	// Host on which the port was probed
+	Name string `json:"name"`
+	Addr string `json:"address"`
+	// Port which violates the policy
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	// Name of the service conventionally assigned to the port, if any
+	Service string `json:"service,omitempty"`
+	// How the port violates the policy
+	Kind Kind `json:"violation"`
+}
+
+// Read reads a policy, translating the port specifications for the specified
+// protocol ("tcp" or "udp").  The host specifications are translated into
+// addresses immediately (so host names are resolved only once).
+func Read(r io.Reader, protocol string) (*Policy, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	p := &Policy{}
+	if err := dec.Decode(p); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range p.Rules {
+		if rule == nil {
+			return nil, fmt.Errorf("rule %d is empty", i+1)
+		}
+		if err := rule.parse(protocol); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+	}
+	return p, nil
+}
+
+// ReadFile reads a policy from the specified file, as by Read.
+func ReadFile(name, protocol string) (*Policy, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := Read(f, protocol)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return p, nil
+}
+
+// parse translates the host and port specifications of the rule.
+func (r *Rule) parse(protocol string) error {
+	if r.Hosts != "" {
+		list, err := targets.Parse(r.Hosts)
+		if err != nil {
+			return fmt.Errorf("invalid hosts:  %v", err)
+		}
+		r.hosts = list
+		r.addrs = make(map[string]bool)
+		for _, t := range list {
+			r.addrs[t.Addr] = true
+		}
+	}
+
+	specs := []struct {
+		name  string
+		spec  string
+		ports *[]int
+	}{
+		{"required", r.Required, &r.required},
+		{"allowed", r.Allowed, &r.allowed},
+		{"forbidden", r.Forbidden, &r.forbidden},
+	}
+	for _, s := range specs {
+		if s.spec == "" {
+			continue
+		}
+		ports, err := portlist.Parse(s.spec, protocol)
+		if err != nil {
+			return fmt.Errorf("invalid %s ports:  %v", s.name, err)
+		}
+		*s.ports = ports
+	}
+	return nil
+}
+
+// applies returns a boolean indicating whether the rule applies to the host
+// with the specified address.
+func (r *Rule) applies(addr string) bool {
+	return r.addrs == nil || r.addrs[addr]
+}
+
+// Check returns the violations of the policy by the results of the scan,
+// ordered by host (in the order of the scan) and port, followed by those of
+// the hosts named by the rules which have no results (in the order of the
+// rules).  Only the ports which were found to be open (e.g., not
+// open|filtered) are considered to be open.
+func (p *Policy) Check(s *report.Scan) []Violation {
+	probed := make(map[int]bool)
+	for _, port := range s.Ports {
+		probed[port] = true
+	}
+
+	violations := []Violation{}
+	scanned := make(map[string]bool)
+	for _, h := range s.Hosts {
+		scanned[h.Addr] = true
+		required := make(map[int]bool)
+		allowed := make(map[int]bool)
+		forbidden := make(map[int]bool)
+		exclusive := false
+		for _, r := range p.Rules {
+			if !r.applies(h.Addr) {
+				continue
+			}
+			for _, port := range r.required {
+				required[port] = true
+				allowed[port] = true
+			}
+			for _, port := range r.allowed {
+				allowed[port] = true
+			}
+			for _, port := range r.forbidden {
+				forbidden[port] = true
+			}
+			exclusive = exclusive || r.Exclusive
+		}
+
+		open := make(map[int]bool)
+		for _, port := range h.Ports {
+			if port.State.IsOpen() {
+				open[port.Port] = true
+			}
+		}
+
+		kinds := make(map[int]Kind)
+		for port := range required {
+			switch {
+			case !probed[port]:
+				kinds[port] = Unprobed
+			case !open[port]:
+				kinds[port] = Missing
+			}
+		}
+		for port := range open {
+			switch {
+			case forbidden[port]:
+				kinds[port] = Forbidden
+			case exclusive && !allowed[port]:
+				kinds[port] = Unexpected
+			}
+		}
+
+		ports := make([]int, 0, len(kinds))
+		for port := range kinds {
+			ports = append(ports, port)
+		}
+		sort.Ints(ports)
+		for _, port := range ports {
+			violations = append(violations, Violation{
+				Name:     h.Name,
+				Addr:     h.Addr,
+				Port:     port,
+				Protocol: s.Protocol,
+				Service:  report.Service(s.Protocol, port),
+				Kind:     kinds[port],
+			})
+		}
+	}
+
+	for _, r := range p.Rules {
+		for _, t := range r.hosts {
+			if scanned[t.Addr] {
+				continue
+			}
+			scanned[t.Addr] = true
+			violations = append(violations, Violation{
+				Name:     t.Name,
+				Addr:     t.Addr,
+				Protocol: s.Protocol,
+				Kind:     Unscanned,
+			})
+		}
+	}
+	return violations
+}
+
+// WriteViolations writes the violations (as returned by Check) in
+// human-readable form, listing the offending ports on each host.
+func WriteViolations(w io.Writer, violations []Violation) error {
+	var buf bytes.Buffer
+	if len(violations) == 0 {
+		fmt.Fprintln(&buf, "No policy violations.")
+	}
+	for i, v := range violations {
+		if i == 0 || v.Addr != violations[i-1].Addr {
+			h := report.Host{Name: v.Name, Addr: v.Addr}
+			fmt.Fprintf(&buf, "Policy violations for %s ports on %v:\n",
+				v.Protocol, &h)
+		}
+		if v.Kind == Unscanned {
+			fmt.Fprintln(&buf, kindText[v.Kind])
+			continue
+		}
+		fmt.Fprint(&buf, v.Port)
+		if v.Service != "" {
+			fmt.Fprintf(&buf, " (%s)", v.Service)
+		}
+		fmt.Fprintf(&buf, ": %s\n", kindText[v.Kind])
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
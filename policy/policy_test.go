@@ -0,0 +1,149 @@
+// Unit tests for package policy
+package policy
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/portprobe"
+	"github.com/webbnh/DigitalOcean/report"
+)
+
+const testPolicy = `{
+  "rules": [
+    {"hosts": "192.0.2.1", "required": "22,443", "exclusive": true},
+    {"hosts": "192.0.2.0/30", "allowed": "80"},
+    {"required": "65001", "forbidden": "23,65010-65020"}
+  ]
+}`
+
+func TestRead(t *testing.T) {
+	p, err := Read(strings.NewReader(testPolicy), "tcp")
+	if err != nil {
+		t.Fatalf("Read() returned \"%v\".\n", err)
+	}
+	if len(p.Rules) != 3 {
+		t.Fatalf("Got %d rules; expected 3.\n", len(p.Rules))
+	}
+
+	r := p.Rules[0]
+	if !reflect.DeepEqual(r.addrs, map[string]bool{"192.0.2.1": true}) ||
+		!reflect.DeepEqual(r.required, []int{22, 443}) ||
+		r.allowed != nil || r.forbidden != nil || !r.Exclusive {
+		t.Errorf("Got unexpected rule %+v.\n", r)
+	}
+	if r := p.Rules[1]; len(r.addrs) != 4 || !r.applies("192.0.2.3") ||
+		r.applies("192.0.2.4") {
+		t.Errorf("Got unexpected rule %+v.\n", r)
+	}
+	if r := p.Rules[2]; r.addrs != nil || !r.applies("198.51.100.1") ||
+		len(r.forbidden) != 12 || r.Exclusive {
+		t.Errorf("Got unexpected rule %+v.\n", r)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	cases := []string{
+		``,
+		`{"rules": [`,
+		`{"rules": [null]}`,
+		`{"rules": [{"hosts": "192.0.2.1/99"}]}`,
+		`{"rules": [{"required": "22-x"}]}`,
+		`{"rules": [{"allowed": "99999"}]}`,
+		`{"rules": [{"forbidden": "no-such-service"}]}`,
+		`{"rules": [{"require": "22"}]}`,
+	}
+
+	for _, v := range cases {
+		if _, err := Read(strings.NewReader(v), "tcp"); err == nil {
+			t.Errorf("Read(%q) unexpectedly succeeded.\n", v)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p, err := Read(strings.NewReader(testPolicy), "tcp")
+	if err != nil {
+		t.Fatalf("Read() returned \"%v\".\n", err)
+	}
+
+	s := report.New("webbscan", "tcp",
+		[]int{22, 23, 80, 8080, 65001, 65002}, time.Now())
+	h := s.AddHost("web", "192.0.2.1")
+	s.Add(h, 22, portprobe.Open)
+	s.Add(h, 23, portprobe.Open)
+	s.Add(h, 80, portprobe.Open)
+	s.Add(h, 8080, portprobe.OpenFiltered)
+	s.Add(h, 65001, portprobe.Open)
+	s.Add(h, 65002, portprobe.Open)
+	h = s.AddHost("", "192.0.2.2")
+	s.Add(h, 22, portprobe.Open)
+	s.Add(h, 65001, portprobe.Closed)
+	h = s.AddHost("", "198.51.100.1")
+	s.Add(h, 8080, portprobe.Open)
+	s.Add(h, 65001, portprobe.Open)
+
+	violation := func(name, addr string, port int, kind Kind) Violation {
+		return Violation{Name: name, Addr: addr, Port: port,
+			Protocol: "tcp", Service: report.Service("tcp", port),
+			Kind: kind}
+	}
+	expected := []Violation{
+		violation("web", "192.0.2.1", 23, Forbidden),
+		violation("web", "192.0.2.1", 443, Unprobed),
+		violation("web", "192.0.2.1", 65002, Unexpected),
+		violation("", "192.0.2.2", 65001, Missing),
+		{Name: "192.0.2.0", Addr: "192.0.2.0", Protocol: "tcp",
+			Kind: Unscanned},
+		{Name: "192.0.2.3", Addr: "192.0.2.3", Protocol: "tcp",
+			Kind: Unscanned},
+	}
+	if got := p.Check(s); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Check() returned %+v; expected %+v.\n", got, expected)
+	}
+}
+
+func TestWriteViolations(t *testing.T) {
+	violations := []Violation{
+		{Name: "web", Addr: "192.0.2.1", Port: 23, Protocol: "tcp",
+			Service: "telnet", Kind: Forbidden},
+		{Name: "web", Addr: "192.0.2.1", Port: 65001, Protocol: "tcp",
+			Kind: Unexpected},
+		{Addr: "192.0.2.2", Port: 443, Protocol: "tcp",
+			Service: "https", Kind: Missing},
+		{Addr: "192.0.2.2", Port: 444, Protocol: "tcp",
+			Kind: Unprobed},
+		{Name: "db", Addr: "192.0.2.3", Protocol: "tcp",
+			Kind: Unscanned},
+	}
+
+	cases := []struct {
+		violations []Violation
+		expected   string
+	}{
+		{nil, "No policy violations.\n"},
+		{violations, "Policy violations for tcp ports on web " +
+			"(192.0.2.1):\n" +
+			"23 (telnet): forbidden port is open\n" +
+			"65001: port is open but not allowed\n" +
+			"Policy violations for tcp ports on 192.0.2.2:\n" +
+			"443 (https): required port is not open\n" +
+			"444: required port was not probed\n" +
+			"Policy violations for tcp ports on db (192.0.2.3):\n" +
+			"host was not scanned\n"},
+	}
+
+	for _, v := range cases {
+		var buf bytes.Buffer
+		if err := WriteViolations(&buf, v.violations); err != nil {
+			t.Fatalf("WriteViolations() returned \"%v\".\n", err)
+		}
+		if buf.String() != v.expected {
+			t.Errorf("Got:\n%s\nexpected:\n%s\n", buf.String(),
+				v.expected)
+		}
+	}
+}
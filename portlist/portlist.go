@@ -0,0 +1,124 @@
+// Package portlist provides support for parsing port specifications, such as
+// those given on the command line, into lists of port numbers.
+//
+// A specification is a comma-separated list of entries, each of which may be
+// a single port ("22"), a range of ports ("8000-8100"), a range which is open
+// at one end ("-1024" is ports 1 through 1024, "60000-" is ports 60000
+// through 65535, and "-" is all ports), or a service name ("ssh") which is
+// translated to a port number using package portserv.
+package portlist
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/webbnh/DigitalOcean/portserv"
+)
+
+// The range of valid port numbers
+const (
+	MinPort = 1
+	MaxPort = 65535
+)
+
+// All returns a list of all the valid port numbers.
+func All() []int {
+	ports := make([]int, 0, MaxPort-MinPort+1)
+	for p := MinPort; p <= MaxPort; p++ {
+		ports = append(ports, p)
+	}
+	return ports
+}
+
+// Parse translates the specification into an ordered list of unique port
+// numbers.  Service names are looked up for the specified protocol ("tcp" or
+// "udp").
+func Parse(spec, protocol string) ([]int, error) {
+	var lookup func(string) int
+	switch protocol {
+	case "tcp":
+		lookup = portserv.TcpPort
+	case "udp":
+		lookup = portserv.UdpPort
+	default:
+		return nil, fmt.Errorf("\"%s\" protocol is not supported",
+			protocol)
+	}
+
+	seen := make(map[int]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			return nil, fmt.Errorf("empty entry in port list \"%s\"",
+				spec)
+		}
+
+		low, high, err := parseEntry(entry, lookup)
+		if err != nil {
+			return nil, err
+		}
+		for p := low; p <= high; p++ {
+			seen[p] = true
+		}
+	}
+
+	ports := make([]int, 0, len(seen))
+	for p := range seen {
+		ports = append(ports, p)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// parseEntry translates a single entry from a port specification into the
+// (inclusive) range of port numbers which it denotes.
+func parseEntry(entry string, lookup func(string) int) (int, int, error) {
+	// An entry which doesn't start with a digit or a dash is a service
+	// name.
+	if c := entry[0]; c != '-' && (c < '0' || c > '9') {
+		port := lookup(entry)
+		if port == 0 {
+			return 0, 0, fmt.Errorf("unknown service \"%s\"", entry)
+		}
+		return port, port, nil
+	}
+
+	lowStr, highStr, isRange := strings.Cut(entry, "-")
+	if !isRange {
+		port, err := parsePort(entry)
+		return port, port, err
+	}
+
+	low, high := MinPort, MaxPort
+	var err error
+	if lowStr != "" {
+		if low, err = parsePort(lowStr); err != nil {
+			return 0, 0, err
+		}
+	}
+	if highStr != "" {
+		if high, err = parsePort(highStr); err != nil {
+			return 0, 0, err
+		}
+	}
+	if low > high {
+		return 0, 0, fmt.Errorf("port range \"%s\" is backwards", entry)
+	}
+	return low, high, nil
+}
+
+// parsePort translates a string into a port number, checking that it is in
+// the valid range.
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port number \"%s\"", s)
+	}
+	if port < MinPort || port > MaxPort {
+		return 0, fmt.Errorf("port number %d is out of range (%d-%d)",
+			port, MinPort, MaxPort)
+	}
+	return port, nil
+}
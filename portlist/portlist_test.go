@@ -0,0 +1,88 @@
+// Unit tests for package portlist.
+package portlist
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/webbnh/DigitalOcean/portserv"
+)
+
+func TestAll(t *testing.T) {
+	ports := All()
+	if len(ports) != MaxPort-MinPort+1 {
+		t.Fatalf("Got %d ports; expected %d.\n",
+			len(ports), MaxPort-MinPort+1)
+	}
+	for i, p := range ports {
+		if p != i+MinPort {
+			t.Fatalf("Port #%d is %d; expected %d.\n",
+				i, p, i+MinPort)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		spec     string
+		expected []int
+		isErr    bool
+	}{
+		{"22", []int{22}, false},
+		{"22,80,443", []int{22, 80, 443}, false},
+		{"443, 80,22,80", []int{22, 80, 443}, false},
+		{"8000-8003", []int{8000, 8001, 8002, 8003}, false},
+		{"-3", []int{1, 2, 3}, false},
+		{"65533-", []int{65533, 65534, 65535}, false},
+		{"5,1-3,2-4", []int{1, 2, 3, 4, 5}, false},
+		{"", nil, true},
+		{"22,,80", nil, true},
+		{"0", nil, true},
+		{"65536", nil, true},
+		{"10-5", nil, true},
+		{"1-2-3", nil, true},
+		{"22x", nil, true},
+		{"no-such-service", nil, true},
+	}
+
+	for i, v := range cases {
+		got, err := Parse(v.spec, "tcp")
+		if (err != nil) != v.isErr {
+			t.Errorf("Case #%d: Parse(\"%s\") returned error \"%v\".\n",
+				i, v.spec, err)
+			continue
+		}
+		if !v.isErr && !reflect.DeepEqual(got, v.expected) {
+			t.Errorf("Case #%d: Parse(\"%s\") returned %v; expected %v.\n",
+				i, v.spec, got, v.expected)
+		}
+	}
+
+	if ports, err := Parse("-", "udp"); err != nil || len(ports) != len(All()) {
+		t.Errorf("Parse(\"-\") returned %d ports, \"%v\"; expected %d.\n",
+			len(ports), err, len(All()))
+	}
+
+	if _, err := Parse("22", "sctp"); err == nil {
+		t.Error("Parse() unexpectedly accepted protocol \"sctp\".")
+	}
+}
+
+func TestParseService(t *testing.T) {
+	// Pick an arbitrary service from the services file.
+	for p := MinPort; p <= MaxPort; p++ {
+		service := portserv.Tcp(p)
+		if service == "" || service[0] == '-' ||
+			(service[0] >= '0' && service[0] <= '9') {
+			continue
+		}
+		expected := portserv.TcpPort(service)
+		got, err := Parse(service, "tcp")
+		if err != nil || !reflect.DeepEqual(got, []int{expected}) {
+			t.Errorf("Parse(\"%s\") returned %v, \"%v\"; expected [%d].\n",
+				service, got, err, expected)
+		}
+		return
+	}
+	t.Skip("No TCP services defined.")
+}
@@ -0,0 +1,195 @@
+package portprobe
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Payload is a message to be sent in a UDP probe.  Most UDP services ignore
+// messages which they don't understand, so a probe which speaks the service's
+// protocol is much more likely to elicit a reply (which shows that the port is
+// open) than a generic one.
+type Payload struct {
+	// Name of the protocol (e.g., "dns")
+	Name string
+	// Message to be sent
+	Data []byte
+	// If not nil, reports whether a reply is a valid response in the
+	// protocol (which confirms that the service is present)
+	Valid func(reply []byte) bool
+}
+
+// The payload sent to ports which have no protocol-specific payload
+var genericPayload = Payload{Name: "generic", Data: []byte("Some UDP message")}
+
+// The protocol-specific payloads, by port
+var payloads = map[int]Payload{
+	53:   {"dns", dnsQuery, validDNS},
+	69:   {"tftp", tftpRequest, validTFTP},
+	123:  {"ntp", ntpRequest, validNTP},
+	137:  {"netbios-ns", netbiosQuery, validNetBIOS},
+	161:  {"snmp", snmpGetRequest, validSNMP},
+	1900: {"ssdp", ssdpSearch, validSSDP},
+	5353: {"mdns", dnsQuery, validDNS},
+}
+
+// RegisterPayload sets the payload to be sent in probes of the specified UDP
+// port, replacing any existing one.  It must not be called while probes are
+// in progress.
+func RegisterPayload(port int, p Payload) {
+	payloads[port] = p
+}
+
+// LookupPayload returns the payload to be sent in probes of the specified UDP
+// port.
+func LookupPayload(port int) Payload {
+	if p, ok := payloads[port]; ok {
+		return p
+	}
+	return genericPayload
+}
+
+// dnsQuery is a standard query for the name servers of the root zone.
+var dnsQuery = []byte{
+	0x57, 0x42, // ID
+	0x01, 0x00, // Flags:  recursion desired
+	0x00, 0x01, // One question
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // No other records
+	0x00,       // Name:  the root
+	0x00, 0x02, // Type:  NS
+	0x00, 0x01, // Class:  IN
+}
+
+// validDNS reports whether the reply is a DNS response to dnsQuery.
+func validDNS(reply []byte) bool {
+	return len(reply) >= 12 && bytes.Equal(reply[:2], dnsQuery[:2]) &&
+		reply[2]&0x80 != 0
+}
+
+// tftpRequest is a read request for a (presumably non-existent) file.
+var tftpRequest = []byte("\x00\x01webbscan.txt\x00octet\x00")
+
+// validTFTP reports whether the reply is a TFTP data or error packet.
+func validTFTP(reply []byte) bool {
+	return len(reply) >= 4 && reply[0] == 0 &&
+		(reply[1] == 3 || reply[1] == 5)
+}
+
+// ntpRequest is an NTP version 4 client request.
+var ntpRequest = append([]byte{0xe3}, make([]byte, 47)...)
+
+// validNTP reports whether the reply is an NTP server (or broadcast) packet.
+func validNTP(reply []byte) bool {
+	if len(reply) < 48 {
+		return false
+	}
+	mode := reply[0] & 0x07
+	return mode == 4 || mode == 5
+}
+
+// netbiosQuery is a NetBIOS node status request for the wildcard name.
+var netbiosQuery = append([]byte{
+	0x57, 0x42, // ID
+	0x00, 0x00, // Flags
+	0x00, 0x01, // One question
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // No other records
+	0x20, 'C', 'K', // Name:  "*" (encoded, padded with "AA")
+}, append(bytes.Repeat([]byte("A"), 30),
+	0x00,       // End of name
+	0x00, 0x21, // Type:  NBSTAT
+	0x00, 0x01, // Class:  IN
+)...)
+
+// validNetBIOS reports whether the reply is a NetBIOS name service response
+// to netbiosQuery.
+func validNetBIOS(reply []byte) bool {
+	return len(reply) >= 12 && bytes.Equal(reply[:2], netbiosQuery[:2]) &&
+		reply[2]&0x80 != 0
+}
+
+// snmpGetRequest is an SNMPv1 get-request for sysDescr.0, using the
+// community "public".
+var snmpGetRequest = []byte{
+	0x30, 0x29, // Message
+	0x02, 0x01, 0x00, // Version:  1
+	0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c', // Community
+	0xa0, 0x1c, // GetRequest PDU
+	0x02, 0x04, 0x57, 0x42, 0x00, 0x01, // Request ID
+	0x02, 0x01, 0x00, // Error status
+	0x02, 0x01, 0x00, // Error index
+	0x30, 0x0e, // Variable bindings
+	0x30, 0x0c, // Variable binding
+	0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, // OID
+	0x05, 0x00, // Value:  NULL
+}
+
+// validSNMP reports whether the reply is an SNMP message containing a
+// GetResponse PDU.
+func validSNMP(reply []byte) bool {
+	// Enter the message sequence, then skip the version and the
+	// community, which should leave the PDU.
+	body, ok := berContents(reply, 0x30)
+	if !ok {
+		return false
+	}
+	for i := 0; i < 2; i++ {
+		if body, ok = berSkip(body); !ok {
+			return false
+		}
+	}
+	_, ok = berContents(body, 0xa2)
+	return ok
+}
+
+// berContents returns the contents of the BER-encoded element at the start
+// of data, if it has the specified tag.
+func berContents(data []byte, tag byte) ([]byte, bool) {
+	if len(data) < 2 || data[0] != tag {
+		return nil, false
+	}
+	header, length := berLength(data[1:])
+	if header == 0 || 1+header+length > len(data) {
+		return nil, false
+	}
+	return data[1+header : 1+header+length], true
+}
+
+// berSkip returns whatever follows the BER-encoded element at the start of
+// data.
+func berSkip(data []byte) ([]byte, bool) {
+	if len(data) < 2 {
+		return nil, false
+	}
+	header, length := berLength(data[1:])
+	if header == 0 || 1+header+length > len(data) {
+		return nil, false
+	}
+	return data[1+header+length:], true
+}
+
+// berLength decodes the BER length at the start of data, returning the number
+// of bytes which it occupies (zero, if it is invalid) and its value.
+func berLength(data []byte) (int, int) {
+	if data[0] < 0x80 {
+		return 1, int(data[0])
+	}
+	n := int(data[0] & 0x7f)
+	if n == 0 || n > 4 || len(data) < 1+n {
+		return 0, 0
+	}
+	var buf [4]byte
+	copy(buf[4-n:], data[1:1+n])
+	return 1 + n, int(binary.BigEndian.Uint32(buf[:]))
+}
+
+// ssdpSearch is an SSDP discovery request.
+var ssdpSearch = []byte("M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 1\r\n" +
+	"ST: ssdp:all\r\n\r\n")
+
+// validSSDP reports whether the reply is an HTTP-style SSDP response.
+func validSSDP(reply []byte) bool {
+	return bytes.HasPrefix(reply, []byte("HTTP/1."))
+}
@@ -0,0 +1,109 @@
+// Unit tests for the UDP payloads of package portprobe.
+package portprobe
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLookupPayload(t *testing.T) {
+	cases := []struct {
+		port int
+		name string
+	}{
+		{53, "dns"},
+		{69, "tftp"},
+		{123, "ntp"},
+		{137, "netbios-ns"},
+		{161, "snmp"},
+		{1900, "ssdp"},
+		{5353, "mdns"},
+		{9, "generic"},
+	}
+
+	for _, v := range cases {
+		if p := LookupPayload(v.port); p.Name != v.name ||
+			len(p.Data) == 0 {
+			t.Errorf("LookupPayload(%d) returned %+v; expected "+
+				"\"%s\".\n", v.port, p, v.name)
+		}
+	}
+
+	RegisterPayload(9, Payload{Name: "discard", Data: []byte("x")})
+	defer delete(payloads, 9)
+	if p := LookupPayload(9); p.Name != "discard" {
+		t.Errorf("LookupPayload(9) returned %+v after "+
+			"RegisterPayload().\n", p)
+	}
+}
+
+func TestValidReplies(t *testing.T) {
+	// A DNS response to the query, with the same ID
+	dnsReply := append([]byte{0x57, 0x42, 0x81, 0x80}, dnsQuery[4:]...)
+	// An NTP server packet
+	ntpReply := append([]byte{0x24}, make([]byte, 47)...)
+	// A NetBIOS response with the same ID
+	nbReply := append([]byte{0x57, 0x42, 0x84, 0x00}, netbiosQuery[4:]...)
+	// An SNMP GetResponse, made from the request by changing the PDU tag
+	snmpReply := bytes.Clone(snmpGetRequest)
+	snmpReply[13] = 0xa2
+
+	cases := []struct {
+		name  string
+		valid func([]byte) bool
+		reply []byte
+		ok    bool
+	}{
+		{"dns", validDNS, dnsReply, true},
+		{"dns query", validDNS, dnsQuery, false},
+		{"dns other ID", validDNS, append([]byte{0x12, 0x34},
+			dnsReply[2:]...), false},
+		{"dns short", validDNS, dnsReply[:11], false},
+		{"tftp error", validTFTP, []byte("\x00\x05\x00\x01No file\x00"),
+			true},
+		{"tftp data", validTFTP, []byte("\x00\x03\x00\x01"), true},
+		{"tftp request", validTFTP, tftpRequest, false},
+		{"ntp", validNTP, ntpReply, true},
+		{"ntp request", validNTP, ntpRequest, false},
+		{"ntp short", validNTP, ntpReply[:47], false},
+		{"netbios", validNetBIOS, nbReply, true},
+		{"netbios query", validNetBIOS, netbiosQuery, false},
+		{"snmp", validSNMP, snmpReply, true},
+		{"snmp request", validSNMP, snmpGetRequest, false},
+		{"snmp truncated", validSNMP, snmpReply[:20], false},
+		{"snmp garbage", validSNMP, []byte("\x30\x84\xff\xff"), false},
+		{"ssdp", validSSDP, []byte("HTTP/1.1 200 OK\r\n\r\n"), true},
+		{"ssdp request", validSSDP, ssdpSearch, false},
+	}
+
+	for _, v := range cases {
+		if got := v.valid(v.reply); got != v.ok {
+			t.Errorf("Case \"%s\":  valid() returned %v; expected "+
+				"%v.\n", v.name, got, v.ok)
+		}
+	}
+}
+
+func TestBERLength(t *testing.T) {
+	cases := []struct {
+		data   []byte
+		header int
+		length int
+	}{
+		{[]byte{0x05}, 1, 5},
+		{[]byte{0x81, 0xc8}, 2, 200},
+		{[]byte{0x82, 0x01, 0x00}, 3, 256},
+		{[]byte{0x80}, 0, 0},
+		{[]byte{0x82, 0x01}, 0, 0},
+		{[]byte{0x85, 1, 2, 3, 4, 5}, 0, 0},
+	}
+
+	for _, v := range cases {
+		header, length := berLength(v.data)
+		if header != v.header || length != v.length {
+			t.Errorf("berLength(%x) returned %d, %d; expected %d, "+
+				"%d.\n", v.data, header, length, v.header,
+				v.length)
+		}
+	}
+}
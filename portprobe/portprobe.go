@@ -2,74 +2,314 @@
 package portprobe
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/webbnh/DigitalOcean/vdiag"
 )
 
-// Port statuses
+// Port statuses, the possible values of a Result
 const (
-	closed = iota - 1
-	pending
-	open
+	// The target refused the probe
+	Closed Result = iota - 1
+	// The probe has not completed
+	Pending
+	// The target responded to the probe
+	Open
+	// No response, presumably because a firewall dropped it
+	Filtered
+	// No response, but the port might be open (UDP)
+	OpenFiltered
+	// The probe could not be performed
+	Failed
 )
 
+// Reason is the reason for the result of a probe, classifying the response
+// (or lack of one) or the error which determined the port status.
+type Reason int
+
+// Reasons for port statuses
+const (
+	// The reason is not recorded
+	ReasonNone Reason = iota
+	// The target actively refused the connection or datagram
+	ReasonRefused
+	// The target did not respond in time
+	ReasonTimeout
+	// The target host or network was reported to be unreachable
+	ReasonUnreachable
+	// The local system did not permit the probe
+	ReasonPermission
+	// The target responded to the probe
+	ReasonResponse
+	// Some other error occurred
+	ReasonOther
+	// The target sent a valid reply in the protocol of the service
+	// expected on the port
+	ReasonConfirmed
+)
+
+// Default time to wait for a response to a UDP probe
 const readTimeout = 1 * time.Second
 
+// Default time to wait for a TCP service to send a banner
+const bannerTimeout = 2 * time.Second
+
+// Maximum size of a banner
+const maxBanner = 1024
+
+// Default and maximum times to wait before retrying a probe
+const (
+	defaultBackoff = 100 * time.Millisecond
+	maxBackoff     = 5 * time.Second
+)
+
+// Prober performs probes using a set of parameters.  The zero value is
+// usable, and applies the default parameters.
+type Prober struct {
+	// Maximum time to wait for a TCP connection to be established (0:
+	// limited only by the operating system)
+	ConnectTimeout time.Duration
+	// Time to wait for a response to a UDP probe (0: one second)
+	UDPTimeout time.Duration
+	// Number of times to retry a probe whose outcome is inconclusive
+	// (i.e., which timed out)
+	Retries int
+	// Time to wait before the first retry; the time is doubled for each
+	// subsequent retry, up to a maximum of five seconds (0: 100ms)
+	Backoff time.Duration
+	// If true, after connecting to an open TCP port, read whatever the
+	// service sends first (e.g., an SSH or SMTP greeting)
+	Banners bool
+	// Time to wait for the service to send a banner (0: two seconds)
+	BannerTimeout time.Duration
+}
+
+// Response is the result of a probe, along with any data which the service
+// sent in response.
+type Response struct {
+	// Result of the probe (e.g., open, closed)
+	Result Result
+	// Reason for the result (e.g., the target refused the connection)
+	Reason Reason
+	// Number of attempts which were made to probe the port (zero, if the
+	// probe was abandoned)
+	Attempts int
+	// The first bytes sent by the service, if banners were requested and
+	// the service sent any
+	Banner []byte
+}
+
 // Result is the result of the probe; the appropriate "IsXXXX()" function
 // should be used to evaluate it.
 type Result int
 
 // IsClosed returns a boolean indicating whether the port is closed.
-func (r Result) IsClosed() bool { return r == closed }
+func (r Result) IsClosed() bool { return r == Closed }
 
 // IsComplete returns a boolean indicating whether the probe has completed.
-func (r Result) IsComplete() bool { return r != pending }
+func (r Result) IsComplete() bool { return r != Pending }
 
 // IsClosed returns a boolean indicating whether the port is open.
-func (r Result) IsOpen() bool { return r == open }
+func (r Result) IsOpen() bool { return r == Open }
+
+// IsFiltered returns a boolean indicating whether the probe went unanswered,
+// so that the port may be filtered (this includes ports which might instead
+// be open).
+func (r Result) IsFiltered() bool {
+	return r == Filtered || r == OpenFiltered
+}
+
+// IsOpenFiltered returns a boolean indicating whether the port is either open
+// or filtered (i.e., the probe went unanswered, which is expected of an open
+// UDP port).
+func (r Result) IsOpenFiltered() bool { return r == OpenFiltered }
+
+// IsError returns a boolean indicating whether the probe failed, so that the
+// status of the port is unknown.
+func (r Result) IsError() bool { return r == Failed }
 
 // String returns the probe result as a string.
 func (r Result) String() string {
 	switch r {
-	case closed:
+	case Closed:
 		return "closed"
-	case pending:
+	case Pending:
 		return "pending"
-	case open:
+	case Open:
 		return "open"
+	case Filtered:
+		return "filtered"
+	case OpenFiltered:
+		return "open|filtered"
+	case Failed:
+		return "error"
 	}
 	return "<unrecognized value>"
 }
 
+// MarshalText returns the probe result as text (e.g., for JSON encoding).
+func (r Result) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText sets the probe result from its text form, as produced by
+// MarshalText() (e.g., for JSON decoding).
+func (r *Result) UnmarshalText(text []byte) error {
+	for _, v := range []Result{Closed, Pending, Open, Filtered,
+		OpenFiltered, Failed} {
+		if string(text) == v.String() {
+			*r = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized probe result \"%s\"", text)
+}
+
+// String returns the reason as a string.
+func (r Reason) String() string {
+	switch r {
+	case ReasonNone:
+		return ""
+	case ReasonRefused:
+		return "refused"
+	case ReasonTimeout:
+		return "timeout"
+	case ReasonUnreachable:
+		return "unreachable"
+	case ReasonPermission:
+		return "permission"
+	case ReasonResponse:
+		return "response"
+	case ReasonOther:
+		return "other"
+	case ReasonConfirmed:
+		return "confirmed"
+	}
+	return "<unrecognized value>"
+}
+
+// MarshalText returns the reason as text (e.g., for JSON encoding).
+func (r Reason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText sets the reason from its text form, as produced by
+// MarshalText() (e.g., for JSON decoding).
+func (r *Reason) UnmarshalText(text []byte) error {
+	for v := ReasonNone; v <= ReasonConfirmed; v++ {
+		if string(text) == v.String() {
+			*r = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized probe reason \"%s\"", text)
+}
+
+// classify determines the port status and reason implied by an error
+// returned from a network operation.  Errors which mean that the target
+// refused the probe imply that the port is closed; errors which mean that
+// the probe went unanswered or was blocked in transit imply that the port is
+// filtered (or possibly open, for UDP); and anything else means that the
+// probe failed.
+func classify(err error, unanswered Result) (state Result, reason Reason) {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		state, reason = Closed, ReasonRefused
+	case errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, syscall.ENETUNREACH):
+		state, reason = Filtered, ReasonUnreachable
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		state, reason = Failed, ReasonPermission
+	default:
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			state, reason = unanswered, ReasonTimeout
+		} else {
+			state, reason = Failed, ReasonOther
+		}
+	}
+	return state, reason
+}
+
 // netDialerTCP wraps the TCP version of net.Dial() in an interface so that we
 // can mock it for testing.
 type netDialerTCP interface {
-	Dial(address string) (net.Conn, error)
+	Dial(ctx context.Context, address string) (net.Conn, error)
 }
 
 // dialerTCP implements the netDialerTCP interface by invoking the
 // corresponding functions from package net.
-type dialerTCP struct{}
+type dialerTCP struct {
+	// Maximum time to wait for the connection (0: no limit)
+	timeout time.Duration
+}
 
-func (d dialerTCP) Dial(address string) (net.Conn, error) {
-	return net.Dial("tcp", address)
+func (d dialerTCP) Dial(ctx context.Context, address string) (net.Conn,
+	error) {
+	return (&net.Dialer{Timeout: d.timeout}).DialContext(ctx, "tcp", address)
 }
 
 // probeTcp determines whether the indicated TCP port on the target host is
-// open.
-func probeTcp(d netDialerTCP, node string, port int) Result {
-	address := fmt.Sprintf("%s:%d", node, port)
-	conn, err := d.Dial(address)
+// open.  If the timeout is not zero, it then waits up to that long for the
+// service to send a banner.  If the context is done first, the probe is
+// abandoned.
+func probeTcp(ctx context.Context, d netDialerTCP, node string, port int,
+	timeout time.Duration) Response {
+	address := net.JoinHostPort(node, strconv.Itoa(port))
+	conn, err := d.Dial(ctx, address)
 	if err != nil {
 		vdiag.Out(6, "Dial(tcp:%s) returned \"%v\".\n", address, err)
-		return closed
+		state, reason := classify(err, Filtered)
+		return Response{Result: state, Reason: reason}
+	}
+	defer conn.Close()
+
+	r := Response{Result: Open, Reason: ReasonResponse}
+	if timeout > 0 {
+		r.Banner = readBanner(ctx, conn, timeout)
+	}
+	return r
+}
+
+// interruptRead arranges for a read from the connection (following the
+// setting of its read deadline) to be interrupted when the context is done,
+// and returns a function which cancels the arrangement.
+func interruptRead(ctx context.Context,
+	conn interface{ SetReadDeadline(time.Time) error }) func() bool {
+	return context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+}
+
+// readBanner returns the first bytes which the service on the other end of
+// the connection sends within the specified time, if any.  (Many services,
+// such as SSH, SMTP, FTP, and MySQL, send a greeting as soon as a client
+// connects; others wait for the client to speak first.)  If the context is
+// done first, the read is abandoned.
+func readBanner(ctx context.Context, conn net.Conn,
+	timeout time.Duration) []byte {
+	err := conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		vdiag.Out(5, "SetReadDeadline(%v) returned \"%v\".\n",
+			conn.RemoteAddr(), err)
+		return nil
 	}
-	conn.Close()
-	return open
+	defer interruptRead(ctx, conn)()
+
+	buf := make([]byte, maxBanner)
+	n, err := conn.Read(buf)
+	vdiag.Out(6, "Read(%v) returned %d, \"%v\".\n", conn.RemoteAddr(), n,
+		err)
+	if n == 0 {
+		return nil
+	}
+	return buf[:n]
 }
 
 // netUDPConn is the interface which net.UDPConn implements; any type which
@@ -84,27 +324,37 @@ type netUDPConn interface {
 // netDialerUDP wraps the UDP support in package net in an interface so that
 // we can mock it for testing.
 type netDialerUDP interface {
-	Dial(address string) (netUDPConn, error)
+	Dial(ctx context.Context, address string) (netUDPConn, error)
 }
 
 // dialerUDP implements the netDialerUDP interface by invoking the
 // corresponding functions from package net.
 type dialerUDP struct{}
 
-func (d dialerUDP) Dial(address string) (netUDPConn, error) {
-	conn, err := net.Dial("udp", address)
-	return conn.(*net.UDPConn), err
+func (d dialerUDP) Dial(ctx context.Context, address string) (netUDPConn,
+	error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
 }
 
-// Probe determines whether the indicated UDP port on the target host is open.
-func probeUdp(d netDialerUDP, node string, port int) Result {
-	address := fmt.Sprintf("%s:%d", node, port)
-	conn, err := d.Dial(address)
+// Probe determines whether the indicated UDP port on the target host is open,
+// waiting for a response for the specified time.  The probe carries the
+// payload registered for the port, if any, and a reply which is valid in the
+// payload's protocol confirms that the port is open.  If the context is done
+// first, the probe is abandoned.
+func probeUdp(ctx context.Context, d netDialerUDP, node string, port int,
+	timeout time.Duration) Response {
+	address := net.JoinHostPort(node, strconv.Itoa(port))
+	conn, err := d.Dial(ctx, address)
 	if err != nil {
 		vdiag.Out(6, "Dial(udp:%s) returned \"%v\".\n", address, err)
-		// We failed to establish a connection...if this can ever
-		// happen, assume it means the port is closed.
-		return closed
+		// We failed to establish a connection...this can happen,
+		// e.g., if there is no route to the host.
+		state, reason := classify(err, Filtered)
+		return Response{Result: state, Reason: reason}
 	}
 	defer conn.Close()
 
@@ -113,68 +363,150 @@ func probeUdp(d netDialerUDP, node string, port int) Result {
 	// be closed if we weren't using it.
 	if address == conn.LocalAddr().String() {
 		vdiag.Out(5, "Probing myself!\n")
-		return closed
+		return Response{Result: Closed}
 	}
 
-	m := []byte("Some UDP message")
+	payload := LookupPayload(port)
+	m := payload.Data
 	n, err := conn.Write(m)
 	if err != nil || n != len(m) {
-		panic(fmt.Sprintf("UDP Write() returned %d, \"%v\".\n", n, err))
+		vdiag.Out(5, "Write(%d) returned %d, \"%v\".\n", port, n, err)
+		if err == nil {
+			return Response{Result: Failed, Reason: ReasonOther}
+		}
+		state, reason := classify(err, Filtered)
+		return Response{Result: state, Reason: reason}
 	}
 
-	err = conn.SetReadDeadline(time.Now().Add(readTimeout))
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
 	if err != nil {
-		panic(fmt.Sprintf("UDP SetReadDeadline() returned \"%v\".\n",
-			err))
+		vdiag.Out(5, "SetReadDeadline(%d) returned \"%v\".\n", port, err)
+		return Response{Result: Failed, Reason: ReasonOther}
 	}
+	defer interruptRead(ctx, conn)()
 
-	var buf bytes.Buffer
-	n, _, err = conn.ReadFrom(buf.Bytes())
+	buf := make([]byte, 2048)
+	n, _, err = conn.ReadFrom(buf)
 	if err != nil {
-		if err.(net.Error).Timeout() {
-			// There was something there, but it declined to
-			// respond in a timely fashion:  assume the port is
-			// open.
-			return open
-		}
-
-		// There was an error (likely "connection refused") accessing
-		// the port:  assume that it is closed.
+		// If the read timed out, either nothing was listening but the
+		// target declined to say so, or the probe (or the response)
+		// was dropped, or something was listening but declined to
+		// respond to our message:  the port is open or filtered.  If
+		// the target reported that the port was unreachable (which
+		// shows up as "connection refused"), it is closed.
 		vdiag.Out(5, "ReadFrom(%d) returned %d, \"%v\".\n",
 			port, n, err)
-		return closed
+		state, reason := classify(err, OpenFiltered)
+		return Response{Result: state, Reason: reason}
 	}
 
-	if n > 0 {
-		// Something actually responded to our message!  The port must
-		// be open.
-		vdiag.Out(5, "ReadFrom(%d) returned %d, \"%v\".\n",
-			port, n, buf.Bytes())
-		return open
+	// Something actually responded to our message!  The port must be
+	// open.
+	vdiag.Out(5, "ReadFrom(%d) returned %d, \"%v\".\n", port, n, buf[:n])
+	if payload.Valid != nil && payload.Valid(buf[:n]) {
+		vdiag.Out(5, "Port %d replied in %s.\n", port, payload.Name)
+		return Response{Result: Open, Reason: ReasonConfirmed}
 	}
-
-	// We got a zero-length read...assume failure and that the port is
-	// closed.
-	vdiag.Out(5, "ReadFrom(%d) returned zero without error.\n", port)
-	return closed
+	return Response{Result: Open, Reason: ReasonResponse}
 }
 
-// Instances of the probe functions which can be overridden for unit testing.
+// Instances of the probe functions (and of the function used to wait before
+// retrying) which can be overridden for unit testing.
 var (
 	probeFuncTCP = probeTcp
 	probeFuncUDP = probeUdp
+	sleepFunc    = sleep
 )
 
+// sleep waits for the specified time, unless the context is done first, in
+// which case it returns the context's error.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Probe determines whether the specified port on the on the specified host is
-// potentially accepting input via the specified network protocol.
+// potentially accepting input via the specified network protocol, using the
+// default parameters.
 func Probe(protocol, host string, port int) Result {
+	return (&Prober{}).Probe(protocol, host, port)
+}
+
+// Probe determines whether the specified port on the on the specified host is
+// potentially accepting input via the specified network protocol, using the
+// Prober's parameters.
+func (p *Prober) Probe(protocol, host string, port int) Result {
+	return p.ProbeResponse(protocol, host, port).Result
+}
+
+// ProbeResponse is like Probe, but it also returns any data which the service
+// sent in response to the probe.  If the outcome of a probe is inconclusive,
+// it is retried, after a delay which grows exponentially, up to the number of
+// times allowed; the number of attempts which were made is recorded in the
+// response.
+func (p *Prober) ProbeResponse(protocol, host string, port int) Response {
+	return p.ProbeResponseContext(context.Background(), protocol, host, port)
+}
+
+// ProbeResponseContext is like ProbeResponse, but if the context is done
+// before the probe (including any retries) is complete, the probe is
+// abandoned, and the result is incomplete (see IsComplete).
+func (p *Prober) ProbeResponseContext(ctx context.Context, protocol,
+	host string, port int) Response {
+	backoff := p.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		r := p.probe(ctx, protocol, host, port)
+		if ctx.Err() != nil {
+			return Response{Result: Pending}
+		}
+		if !r.Result.IsComplete() {
+			return r
+		}
+		if r.Reason != ReasonTimeout || attempt > p.Retries {
+			r.Attempts = attempt
+			return r
+		}
+		vdiag.Out(4, "Probe(%s:%s:%d) attempt %d timed out; retrying "+
+			"in %v.\n", protocol, host, port, attempt, backoff)
+		if sleepFunc(ctx, backoff) != nil {
+			return Response{Result: Pending}
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// probe makes a single attempt to probe the specified port on the specified
+// host.
+func (p *Prober) probe(ctx context.Context, protocol, host string,
+	port int) Response {
 	switch protocol {
 	case "tcp":
-		return probeFuncTCP(dialerTCP{}, host, port)
+		var timeout time.Duration
+		if p.Banners {
+			timeout = p.BannerTimeout
+			if timeout == 0 {
+				timeout = bannerTimeout
+			}
+		}
+		return probeFuncTCP(ctx, dialerTCP{p.ConnectTimeout}, host,
+			port, timeout)
 	case "udp":
-		return probeFuncUDP(dialerUDP{}, host, port)
+		timeout := p.UDPTimeout
+		if timeout == 0 {
+			timeout = readTimeout
+		}
+		return probeFuncUDP(ctx, dialerUDP{}, host, port, timeout)
 	default:
 		vdiag.Out(2, "Probe:  unexpected protocol, \"%s\".'n", protocol)
-		return pending
+		return Response{Result: Pending}
 	}
 }
@@ -2,18 +2,22 @@
 package portprobe
 
 import (
+	"context"
 	"errors"
 	"net"
+	"os"
+	"reflect"
 	"strconv"
+	"syscall"
 	"testing"
 	"time"
 )
 
-var results = []Result{closed, pending, open}
+var results = []Result{Closed, Pending, Open, Filtered, OpenFiltered, Failed}
 
 func TestIsComplete(t *testing.T) {
 	for _, v := range results {
-		expected := (v != pending)
+		expected := (v != Pending)
 		got := v.IsComplete()
 		if got != expected {
 			t.Errorf("%v.IsComplete() unexpectedly returned %v.\n",
@@ -24,7 +28,7 @@ func TestIsComplete(t *testing.T) {
 
 func TestIsOpen(t *testing.T) {
 	for _, v := range results {
-		expected := (v == open)
+		expected := (v == Open)
 		got := v.IsOpen()
 		if got != expected {
 			t.Errorf("%v.IsOpen() unexpectedly returned %v.\n",
@@ -35,7 +39,7 @@ func TestIsOpen(t *testing.T) {
 
 func TestIsClosed(t *testing.T) {
 	for _, v := range results {
-		expected := (v == closed)
+		expected := (v == Closed)
 		got := v.IsClosed()
 		if got != expected {
 			t.Errorf("%v.IsClosed() unexpectedly returned %v.\n",
@@ -44,11 +48,45 @@ func TestIsClosed(t *testing.T) {
 	}
 }
 
-// If the result is complete, then IsOpen() should never equal IsClosed();
-// otherwise, they should be equal.
+func TestIsFiltered(t *testing.T) {
+	for _, v := range results {
+		expected := (v == Filtered || v == OpenFiltered)
+		got := v.IsFiltered()
+		if got != expected {
+			t.Errorf("%v.IsFiltered() unexpectedly returned %v.\n",
+				v, got)
+		}
+	}
+}
+
+func TestIsOpenFiltered(t *testing.T) {
+	for _, v := range results {
+		expected := (v == OpenFiltered)
+		got := v.IsOpenFiltered()
+		if got != expected {
+			t.Errorf("%v.IsOpenFiltered() unexpectedly returned %v.\n",
+				v, got)
+		}
+	}
+}
+
+func TestIsError(t *testing.T) {
+	for _, v := range results {
+		expected := (v == Failed)
+		got := v.IsError()
+		if got != expected {
+			t.Errorf("%v.IsError() unexpectedly returned %v.\n",
+				v, got)
+		}
+	}
+}
+
+// IsOpen() and IsClosed() should never both be true.  If the result is
+// definitive (open or closed), then IsOpen() should never equal IsClosed();
+// otherwise, they should both be false.
 func TestOpposition(t *testing.T) {
 	for _, v := range results {
-		if v.IsComplete() {
+		if v == Open || v == Closed {
 			if v.IsOpen() == v.IsClosed() {
 				t.Errorf("%v.IsClosed() is unexpectedly equal"+
 					" to %v.IsOpen():  %v.\n",
@@ -68,12 +106,18 @@ func TestString(t *testing.T) {
 	for _, v := range results {
 		var expected string
 		switch v {
-		case closed:
+		case Closed:
 			expected = "closed"
-		case pending:
+		case Pending:
 			expected = "pending"
-		case open:
+		case Open:
 			expected = "open"
+		case Filtered:
+			expected = "filtered"
+		case OpenFiltered:
+			expected = "open|filtered"
+		case Failed:
+			expected = "error"
 		default:
 			t.Fatalf("Unexpected value for type Result:  %v.\n", v)
 		}
@@ -84,6 +128,84 @@ func TestString(t *testing.T) {
 	}
 }
 
+func TestMarshalText(t *testing.T) {
+	for _, v := range results {
+		got, err := v.MarshalText()
+		if err != nil || string(got) != v.String() {
+			t.Errorf("%v.MarshalText() returned \"%s\", \"%v\".\n",
+				v, got, err)
+		}
+	}
+}
+
+func TestReasonText(t *testing.T) {
+	for reason := ReasonNone; reason <= ReasonConfirmed; reason++ {
+		text, err := reason.MarshalText()
+		if err != nil {
+			t.Errorf("%v.MarshalText() returned \"%v\".\n",
+				reason, err)
+		}
+		var got Reason
+		err = got.UnmarshalText(text)
+		if err != nil || got != reason {
+			t.Errorf("UnmarshalText(\"%s\") returned %v, \"%v\".\n",
+				text, got, err)
+		}
+	}
+
+	var got Reason
+	if err := got.UnmarshalText([]byte("whim")); err == nil {
+		t.Error("UnmarshalText(\"whim\") unexpectedly succeeded.")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	opErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "dial", Net: "tcp",
+			Err: os.NewSyscallError("connect", errno)}
+	}
+	cases := []struct {
+		err    error
+		result Result
+		reason Reason
+	}{
+		{opErr(syscall.ECONNREFUSED), Closed, ReasonRefused},
+		{opErr(syscall.EHOSTUNREACH), Filtered, ReasonUnreachable},
+		{opErr(syscall.ENETUNREACH), Filtered, ReasonUnreachable},
+		{opErr(syscall.EACCES), Failed, ReasonPermission},
+		{opErr(syscall.EPERM), Failed, ReasonPermission},
+		{opErr(syscall.ETIMEDOUT), OpenFiltered, ReasonTimeout},
+		{timeoutErr{t, true}, OpenFiltered, ReasonTimeout},
+		{timeoutErr{t, false}, Failed, ReasonOther},
+		{errors.New("Something broke"), Failed, ReasonOther},
+	}
+
+	for i, v := range cases {
+		got, reason := classify(v.err, OpenFiltered)
+		if got != v.result || reason != v.reason {
+			t.Errorf("Case #%d: classify() returned %v (%v); "+
+				"expected %v (%v).\n", i, got, reason,
+				v.result, v.reason)
+		}
+	}
+}
+
+func TestUnmarshalText(t *testing.T) {
+	for _, v := range results {
+		var got Result
+		err := got.UnmarshalText([]byte(v.String()))
+		if err != nil || got != v {
+			t.Errorf("UnmarshalText(\"%v\") returned %v, \"%v\".\n",
+				v, got, err)
+		}
+	}
+
+	var got Result
+	if err := got.UnmarshalText([]byte("ajar")); err == nil {
+		t.Error("UnmarshalText(\"ajar\") unexpectedly succeeded.")
+	}
+}
+
 // mockDialerTCP implements the netDialerTCP interface
 type mockDialerTCP struct {
 	t               *testing.T
@@ -92,7 +214,8 @@ type mockDialerTCP struct {
 	conn            net.Conn
 }
 
-func (d mockDialerTCP) Dial(address string) (net.Conn, error) {
+func (d mockDialerTCP) Dial(ctx context.Context, address string) (net.Conn,
+	error) {
 	if address != d.expectedAddress {
 		d.t.Errorf("Dial(tcp) got address \"%s\"; expected \"%s\".\n",
 			address, d.expectedAddress)
@@ -109,7 +232,8 @@ type mockDialerUDP struct {
 	conn            netUDPConn
 }
 
-func (d mockDialerUDP) Dial(address string) (netUDPConn, error) {
+func (d mockDialerUDP) Dial(ctx context.Context,
+	address string) (netUDPConn, error) {
 	if address != d.expectedAddress {
 		d.t.Errorf("Dial(udp) got address \"%s\"; expected \"%s\".\n",
 			address, d.expectedAddress)
@@ -228,10 +352,18 @@ func TestProbeTcp(t *testing.T) {
 		address     string
 		err         error
 		result      Result
+		reason      Reason
 		calledClose bool
 	}{
-		{"tcp", address, nil, open, true},
-		{"tcp", address, errors.New("Connection failed"), closed, false},
+		{"tcp", address, nil, Open, ReasonResponse, true},
+		{"tcp", address, errors.New("Connection failed"), Failed,
+			ReasonOther, false},
+		{"tcp", address, syscall.ECONNREFUSED, Closed, ReasonRefused,
+			false},
+		{"tcp", address, timeoutErr{t, true}, Filtered, ReasonTimeout,
+			false},
+		{"tcp", address, syscall.EHOSTUNREACH, Filtered,
+			ReasonUnreachable, false},
 	}
 
 	for i, v := range cases {
@@ -239,10 +371,10 @@ func TestProbeTcp(t *testing.T) {
 		dialer := mockDialerTCP{t, v.address, v.err,
 			mockConn{t, v.network, "", 0, nil, &calledClose, nil,
 				nil, mockAddr{}}}
-		got := probeTcp(dialer, node, port)
-		if got != v.result {
-			t.Errorf("Case #%d: Probe returned %v; expected %v for error \"%v\".\n",
-				i, got, v.result, v.err)
+		got := probeTcp(context.Background(), dialer, node, port, 0)
+		if got.Result != v.result || got.Reason != v.reason {
+			t.Errorf("Case #%d: Probe returned %v (%v); expected %v (%v) for error \"%v\".\n",
+				i, got.Result, got.Reason, v.result, v.reason, v.err)
 		}
 		checkCalled(t, calledClose, v.calledClose, i, "Close")
 	}
@@ -287,28 +419,36 @@ func TestProbeUdp(t *testing.T) {
 		readErr      error
 		readRet      int
 		result       Result
+		reason       Reason
 		calledClose  bool
 		calledWrite  bool
 		calledSetRDL bool
 	}{
-		// Dial() fails (returns non-nil error), result: closed
+		// Dial() fails (returns non-nil error), result: error
 		{"udp", raddress, laddress, errors.New("Connection failed"),
-			nil, 0, closed, false, false, false},
+			nil, 0, Failed, ReasonOther, false, false, false},
+		// Dial() fails (no route to host), result: filtered
+		{"udp", raddress, laddress, syscall.ENETUNREACH, nil, 0,
+			Filtered, ReasonUnreachable, false, false, false},
 		// Target address equals source address, result: closed
-		{"udp", raddress, raddress, nil, nil, 0, closed, true, false,
-			false},
-		// The read times out, result: open
-		{"udp", raddress, laddress, nil, timeoutErr{t, true}, 0, open,
-			true, true, true},
-		// The read returns (non-timeout) error, result: closed
+		{"udp", raddress, raddress, nil, nil, 0, Closed, ReasonNone,
+			true, false, false},
+		// The read times out, result: open|filtered
+		{"udp", raddress, laddress, nil, timeoutErr{t, true}, 0,
+			OpenFiltered, ReasonTimeout, true, true, true},
+		// The read returns (non-timeout) error, result: error
 		{"udp", raddress, laddress, nil, timeoutErr{t, false}, 0,
-			closed, true, true, true},
+			Failed, ReasonOther, true, true, true},
+		// The port is unreachable, result: closed
+		{"udp", raddress, laddress, nil, syscall.ECONNREFUSED, 0,
+			Closed, ReasonRefused, true, true, true},
 		// The read succeeds (non-zero length), result: open
-		{"udp", raddress, laddress, nil, nil, 10, open, true, true,
-			true},
-		// The read returns zero length, result closed
-		{"udp", raddress, laddress, nil, nil, 0, closed, true, true,
-			true},
+		{"udp", raddress, laddress, nil, nil, 10, Open, ReasonResponse,
+			true, true, true},
+		// The read returns zero length (still a response), result:
+		// open
+		{"udp", raddress, laddress, nil, nil, 0, Open, ReasonResponse,
+			true, true, true},
 	}
 
 	for i, v := range cases {
@@ -320,10 +460,12 @@ func TestProbeUdp(t *testing.T) {
 			&mockConn{t, v.network, v.laddr, v.readRet,
 				v.readErr, &calledClose, &calledWrite,
 				&calledSetRDL, mockAddr{t, v.laddr}}}
-		got := probeUdp(dialer, node, rport)
-		if got != v.result {
-			t.Errorf("Case #%d: Probe returned %v; expected %v.\n",
-				i, got, v.result)
+		got := probeUdp(context.Background(), dialer, node, rport,
+			readTimeout)
+		if got.Result != v.result || got.Reason != v.reason {
+			t.Errorf("Case #%d: Probe returned %v (%v); expected "+
+				"%v (%v).\n", i, got.Result, got.Reason, v.result,
+				v.reason)
 		}
 		checkCalled(t, calledClose, v.calledClose, i, "Close")
 		checkCalled(t, calledWrite, v.calledWrite, i, "Write")
@@ -332,20 +474,94 @@ func TestProbeUdp(t *testing.T) {
 	}
 }
 
+func TestReadBanner(t *testing.T) {
+	cases := []struct {
+		sent     string
+		expected []byte
+	}{
+		{"SSH-2.0-OpenSSH_9.6\r\n", []byte("SSH-2.0-OpenSSH_9.6\r\n")},
+		{"", nil},
+	}
+
+	for i, v := range cases {
+		client, server := net.Pipe()
+		go func() {
+			if v.sent != "" {
+				server.Write([]byte(v.sent))
+			}
+		}()
+		got := readBanner(context.Background(), client,
+			50*time.Millisecond)
+		if !reflect.DeepEqual(got, v.expected) {
+			t.Errorf("Case #%d: readBanner() returned %q; "+
+				"expected %q.\n", i, got, v.expected)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestProbeResponse(t *testing.T) {
+	banner := []byte("220 mail.example.com ESMTP\r\n")
+	cases := []struct {
+		prober  Prober
+		timeout time.Duration
+		banner  []byte
+	}{
+		{Prober{}, 0, nil},
+		{Prober{BannerTimeout: 5 * time.Second}, 0, nil},
+		{Prober{Banners: true}, bannerTimeout, banner},
+		{Prober{Banners: true, BannerTimeout: 5 * time.Second},
+			5 * time.Second, banner},
+	}
+
+	defer func() { probeFuncTCP = probeTcp }()
+
+	for i, v := range cases {
+		probeFuncTCP = func(ctx context.Context, d netDialerTCP,
+			host string, port int, timeout time.Duration) Response {
+			if timeout != v.timeout {
+				t.Errorf("Case #%d: Got banner timeout %v; "+
+					"expected %v.\n", i, timeout, v.timeout)
+			}
+			r := Response{Result: Open, Reason: ReasonResponse}
+			if timeout != 0 {
+				r.Banner = banner
+			}
+			return r
+		}
+
+		got := v.prober.ProbeResponse("tcp", "localhost", 25)
+		if !got.Result.IsOpen() || !reflect.DeepEqual(got.Banner, v.banner) {
+			t.Errorf("Case #%d: ProbeResponse returned %v, %q; "+
+				"expected open, %q.\n", i, got.Result, got.Banner,
+				v.banner)
+		}
+	}
+}
+
 func TestProbe(t *testing.T) {
 	host := "localhost"
 	port := 42
 	cases := []struct {
 		protocol       string
+		prober         *Prober
+		timeout        time.Duration
 		result         Result
 		calledProbeTcp bool
 		calledProbeUdp bool
 	}{
-		{"tcp", open, true, false},
-		{"tcp", closed, true, false},
-		{"udp", open, false, true},
-		{"udp", closed, false, true},
-		{"bad", pending, false, false},
+		{"tcp", nil, 0, Open, true, false},
+		{"tcp", nil, 0, Closed, true, false},
+		{"udp", nil, readTimeout, Open, false, true},
+		{"udp", nil, readTimeout, Closed, false, true},
+		{"bad", nil, 0, Pending, false, false},
+		{"tcp", &Prober{ConnectTimeout: 3 * time.Second},
+			3 * time.Second, Open, true, false},
+		{"udp", &Prober{UDPTimeout: 5 * time.Second},
+			5 * time.Second, OpenFiltered, false, true},
+		{"udp", &Prober{ConnectTimeout: 3 * time.Second},
+			readTimeout, OpenFiltered, false, true},
 	}
 
 	for i, v := range cases {
@@ -366,23 +582,44 @@ func TestProbe(t *testing.T) {
 			}
 		}
 
-		probeFuncTCP = func(d netDialerTCP, gotHost string, gotPort int) Result {
+		probeFuncTCP = func(ctx context.Context, d netDialerTCP,
+			gotHost string, gotPort int,
+			bannerTimeout time.Duration) Response {
 			// I assume the compiler checking will suffice for the
-			// dialer parameter.
+			// dialer parameter, other than its timeout.
 			checkHostPort(gotHost, gotPort)
+			if timeout := d.(dialerTCP).timeout; timeout != v.timeout {
+				t.Errorf("Case #%d: Got timeout %v; "+
+					"expected %v.\n", i, timeout, v.timeout)
+			}
+			if bannerTimeout != 0 {
+				t.Errorf("Case #%d: Got banner timeout %v; "+
+					"expected none.\n", i, bannerTimeout)
+			}
 			calledProbeTcp = true
-			return v.result
+			return Response{Result: v.result}
 		}
 
-		probeFuncUDP = func(d netDialerUDP, gotHost string, gotPort int) Result {
+		probeFuncUDP = func(ctx context.Context, d netDialerUDP,
+			gotHost string, gotPort int,
+			timeout time.Duration) Response {
 			// I assume the compiler checking will suffice for the
 			// dialer parameter.
 			checkHostPort(gotHost, gotPort)
+			if timeout != v.timeout {
+				t.Errorf("Case #%d: Got timeout %v; "+
+					"expected %v.\n", i, timeout, v.timeout)
+			}
 			calledProbeUdp = true
-			return v.result
+			return Response{Result: v.result}
 		}
 
-		got := Probe(v.protocol, host, port)
+		var got Result
+		if v.prober == nil {
+			got = Probe(v.protocol, host, port)
+		} else {
+			got = v.prober.Probe(v.protocol, host, port)
+		}
 		if got != v.result {
 			t.Errorf("Case #%d: Probe returned %v; expected %v.\n",
 				i, got, v.result)
@@ -392,3 +629,156 @@ func TestProbe(t *testing.T) {
 		checkCalled(t, calledProbeUdp, v.calledProbeUdp, i, "probeUdp")
 	}
 }
+
+func TestProbeRetry(t *testing.T) {
+	timedOut := Response{Result: Filtered, Reason: ReasonTimeout}
+	responded := Response{Result: Open, Reason: ReasonResponse}
+	refused := Response{Result: Closed, Reason: ReasonRefused}
+	const ms = time.Millisecond
+	cases := []struct {
+		prober    Prober
+		responses []Response // Responses to successive attempts
+		response  Response
+		attempts  int
+		sleeps    []time.Duration
+	}{
+		{Prober{}, []Response{timedOut}, timedOut, 1, nil},
+		{Prober{Retries: 3}, []Response{responded}, responded, 1, nil},
+		{Prober{Retries: 3}, []Response{refused}, refused, 1, nil},
+		{Prober{Retries: 3}, []Response{timedOut, timedOut, responded},
+			responded, 3, []time.Duration{100 * ms, 200 * ms}},
+		{Prober{Retries: 2}, []Response{timedOut, timedOut, timedOut},
+			timedOut, 3, []time.Duration{100 * ms, 200 * ms}},
+		{Prober{Retries: 4, Backoff: 2 * time.Second},
+			[]Response{timedOut, timedOut, timedOut, timedOut,
+				timedOut},
+			timedOut, 5, []time.Duration{2 * time.Second,
+				4 * time.Second, 5 * time.Second, 5 * time.Second}},
+	}
+
+	defer func() {
+		probeFuncTCP = probeTcp
+		sleepFunc = sleep
+	}()
+
+	for i, v := range cases {
+		calls := 0
+		probeFuncTCP = func(ctx context.Context, d netDialerTCP,
+			host string, port int,
+			bannerTimeout time.Duration) Response {
+			if calls >= len(v.responses) {
+				t.Fatalf("Case #%d: Too many attempts (%d).\n",
+					i, calls+1)
+			}
+			calls++
+			return v.responses[calls-1]
+		}
+		var sleeps []time.Duration
+		sleepFunc = func(ctx context.Context, d time.Duration) error {
+			sleeps = append(sleeps, d)
+			return nil
+		}
+
+		got := v.prober.ProbeResponse("tcp", "localhost", 80)
+		if got.Result != v.response.Result ||
+			got.Reason != v.response.Reason {
+			t.Errorf("Case #%d: Probe returned %v (%v); expected "+
+				"%v (%v).\n", i, got.Result, got.Reason,
+				v.response.Result, v.response.Reason)
+		}
+		if got.Attempts != v.attempts {
+			t.Errorf("Case #%d: Probe made %d attempts; expected "+
+				"%d.\n", i, got.Attempts, v.attempts)
+		}
+		if !reflect.DeepEqual(sleeps, v.sleeps) {
+			t.Errorf("Case #%d: Probe waited %v; expected %v.\n",
+				i, sleeps, v.sleeps)
+		}
+	}
+}
+
+func TestProbeCancel(t *testing.T) {
+	defer func() { probeFuncTCP = probeTcp }()
+	probeFuncTCP = func(ctx context.Context, d netDialerTCP, host string,
+		port int, bannerTimeout time.Duration) Response {
+		return Response{Result: Filtered, Reason: ReasonTimeout}
+	}
+
+	// The context is done during the wait before the first retry.
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	p := Prober{Retries: 3, Backoff: time.Minute}
+	got := p.ProbeResponseContext(ctx, "tcp", "localhost", 80)
+	if got.Result.IsComplete() {
+		t.Errorf("ProbeResponseContext() returned %v; expected an "+
+			"incomplete result.\n", got.Result)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("ProbeResponseContext() took %v.\n", elapsed)
+	}
+
+	// The context is done while waiting for a banner.
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	ctx, cancel = context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if banner := readBanner(ctx, client, time.Minute); banner != nil {
+		t.Errorf("readBanner() returned %q.\n", banner)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("readBanner() took %v.\n", elapsed)
+	}
+}
+
+func TestProbeUdpPayload(t *testing.T) {
+	// Start a UDP service which echoes each message, prefixed by the
+	// specified reply.
+	serve := func(reply string) int {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		go func() {
+			buf := make([]byte, 2048)
+			for {
+				n, addr, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				conn.WriteTo(append([]byte(reply), buf[:n]...),
+					addr)
+			}
+		}()
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	}
+
+	valid := func(reply []byte) bool {
+		return string(reply) == "OK:webbscan"
+	}
+	cases := []struct {
+		reply  string
+		reason Reason
+	}{
+		{"OK:", ReasonConfirmed},
+		{"NO:", ReasonResponse},
+	}
+
+	for i, v := range cases {
+		port := serve(v.reply)
+		RegisterPayload(port, Payload{"test", []byte("webbscan"), valid})
+		got := probeUdp(context.Background(), dialerUDP{}, "127.0.0.1",
+			port, time.Second)
+		delete(payloads, port)
+		if got.Result != Open || got.Reason != v.reason {
+			t.Errorf("Case #%d: Probe returned %v (%v); expected "+
+				"open (%v).\n", i, got.Result, got.Reason,
+				v.reason)
+		}
+	}
+}
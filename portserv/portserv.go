@@ -15,6 +15,12 @@ var (
 	udpServices map[int]string = make(map[int]string)
 )
 
+// Service name to port mappings
+var (
+	tcpPorts map[string]int = make(map[string]int)
+	udpPorts map[string]int = make(map[string]int)
+)
+
 // Initialize port to service name mappings by parsing /etc/services.
 func init() {
 	re := regexp.MustCompile(`\n(\S+)\s+(\d+)/(tcp|udp)`)
@@ -35,8 +41,14 @@ func init() {
 		switch v[3] {
 		case "tcp":
 			tcpServices[port] = v[1]
+			if _, ok := tcpPorts[v[1]]; !ok {
+				tcpPorts[v[1]] = port
+			}
 		case "udp":
 			udpServices[port] = v[1]
+			if _, ok := udpPorts[v[1]]; !ok {
+				udpPorts[v[1]] = port
+			}
 		default:
 			panic(fmt.Sprintf("Unexpected protocol value: \"%s\"",
 				v[3]))
@@ -53,3 +65,13 @@ func Tcp(port int) string { return tcpServices[port] }
 // port number, as defined in the /etc/services file.  If there is no
 // definition, an empty string is returned.
 func Udp(port int) string { return udpServices[port] }
+
+// TcpPort returns the TCP port number corresponding to the specified service
+// name, as defined in the /etc/services file.  If there is no definition,
+// zero is returned.
+func TcpPort(service string) int { return tcpPorts[service] }
+
+// UdpPort returns the UDP port number corresponding to the specified service
+// name, as defined in the /etc/services file.  If there is no definition,
+// zero is returned.
+func UdpPort(service string) int { return udpPorts[service] }
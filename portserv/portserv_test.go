@@ -36,3 +36,27 @@ func TestTcp(t *testing.T) {
 func TestUdp(t *testing.T) {
 	check(t, "udp", Udp)
 }
+
+func checkPort(t *testing.T, network string, getService func(int) string,
+	getPort func(string) int) {
+	for i := 1; i <= 65535; i++ {
+		service := getService(i)
+		if service != "" && getPort(service) == 0 {
+			t.Errorf("Service \"%s\"(%s):  no port returned for "+
+				"service of port %d.\n", service, network, i)
+		}
+	}
+
+	if port := getPort("no-such-service"); port != 0 {
+		t.Errorf("Unknown service(%s):  got port %d; expected zero.\n",
+			network, port)
+	}
+}
+
+func TestTcpPort(t *testing.T) {
+	checkPort(t, "tcp", Tcp, TcpPort)
+}
+
+func TestUdpPort(t *testing.T) {
+	checkPort(t, "udp", Udp, UdpPort)
+}
@@ -56,7 +56,7 @@ func (b *Bar) Paint() {
 // character to the bar on the screen.
 func (b *Bar) Update() {
 	b.current++
-	if b.current%(b.total/b.width) == 0 {
+	if b.current*b.width/b.total > (b.current-1)*b.width/b.total {
 		io.WriteString(b.w, "=")
 	}
 }
@@ -103,6 +103,7 @@ func TestUpdate(t *testing.T) {
 
 	bar := New(expectedWidth, expectedSize, &buf)
 	got := make([]byte, 80)
+	extended := 0
 
 	for i := 1; i <= expectedSize; i++ {
 		bar.Update()
@@ -113,7 +114,8 @@ func TestUpdate(t *testing.T) {
 		}
 
 		n, err := buf.Read(got)
-		if bar.current%(bar.total/bar.width) == 0 {
+		if bar.current*bar.width/bar.total >
+			(bar.current-1)*bar.width/bar.total {
 			switch {
 			case n == 0:
 				t.Errorf("Bar was not extended at update %d "+
@@ -140,6 +142,12 @@ func TestUpdate(t *testing.T) {
 			t.Errorf("Bar was unexpectedly extended, update %d.\n",
 				i)
 		}
+		extended += n
+	}
+
+	if extended != expectedWidth {
+		t.Errorf("Bar was extended to %d characters; expected %d.\n",
+			extended, expectedWidth)
 	}
 }
 
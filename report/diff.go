@@ -0,0 +1,173 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ChangeKind identifies the way in which the result for a port differs
+// between two scans.
+type ChangeKind string
+
+// The kinds of changes
+const (
+	// The port was not open, and now it is
+	Opened ChangeKind = "opened"
+	// The port was open, and now it is not
+	Closed ChangeKind = "closed"
+	// The port is still open, but a different service was detected on it
+	Changed ChangeKind = "changed"
+	// The port was open, and now it is in some other state than closed
+	// (e.g., open|filtered), or vice versa
+	StateChanged ChangeKind = "state"
+)
+
+// Change describes a difference between the results for a single port in two
+// scans.
+type Change struct {
+	// Host on which the port was probed
+	Name string `json:"name"`
+	Addr string `json:"address"`
+	// Port which changed
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	// Name of the service conventionally assigned to the port, if any
+	Service string `json:"service,omitempty"`
+	// How the port changed
+	Kind ChangeKind `json:"change"`
+	// Descriptions of the services detected on the port in the old and
+	// new scans, for a change of service, or the old and new states of
+	// the port, for a change of state
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// Diff compares the results of two scans, returning the changes between the
+// earlier scan and the later one, ordered by host (in the order of the later
+// scan) and port.  Only ports which were probed in both scans on hosts which
+// appear in both are compared, since nothing is known about the others.  A
+// port is taken to be open only if it was found to be open (e.g., not
+// open|filtered); a port which was open in one scan and in some other
+// recorded state (e.g., open|filtered, or error) in the other is reported as
+// a change of state, rather than as opened or closed.  A change of service
+// is reported only if the service was detected in both scans.  (If either
+// scan is incomplete, ports which it did not probe may appear to be closed.)
+func Diff(before, after *Scan) ([]Change, error) {
+	if before.Protocol != after.Protocol {
+		return nil, fmt.Errorf("the scans used different protocols "+
+			"(\"%s\" and \"%s\")", before.Protocol, after.Protocol)
+	}
+
+	probed := make(map[int]bool)
+	for _, p := range before.Ports {
+		probed[p] = true
+	}
+	both := make(map[int]bool)
+	for _, p := range after.Ports {
+		both[p] = probed[p]
+	}
+
+	oldHosts := make(map[string]*Host)
+	for _, h := range before.Hosts {
+		oldHosts[h.Addr] = h
+	}
+
+	changes := []Change{}
+	for _, h := range after.Hosts {
+		oh := oldHosts[h.Addr]
+		if oh == nil {
+			continue
+		}
+		oldPorts, newPorts := recordedPorts(oh), recordedPorts(h)
+
+		var numbers []int
+		for n, p := range oldPorts {
+			if both[n] && p.State.IsOpen() {
+				numbers = append(numbers, n)
+			}
+		}
+		for n, p := range newPorts {
+			if both[n] && p.State.IsOpen() &&
+				!oldPorts[n].State.IsOpen() {
+				numbers = append(numbers, n)
+			}
+		}
+		sort.Ints(numbers)
+
+		for _, n := range numbers {
+			c := Change{Name: h.Name, Addr: h.Addr, Port: n,
+				Protocol: after.Protocol,
+				Service:  Service(after.Protocol, n)}
+			o, wasRecorded := oldPorts[n]
+			p, isRecorded := newPorts[n]
+			switch {
+			case !wasRecorded:
+				c.Kind = Opened
+			case !isRecorded:
+				c.Kind = Closed
+			case !o.State.IsOpen() || !p.State.IsOpen():
+				c.Kind = StateChanged
+				c.Old, c.New = o.State.String(),
+					p.State.String()
+			case o.Detected != nil && p.Detected != nil &&
+				o.Detected.String() != p.Detected.String():
+				c.Kind = Changed
+				c.Old, c.New = o.Detected.String(),
+					p.Detected.String()
+			default:
+				continue
+			}
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// recordedPorts returns the ports of the host which were recorded
+// individually (i.e., those which were not found to be closed or filtered),
+// indexed by port number.
+func recordedPorts(h *Host) map[int]Port {
+	ports := make(map[int]Port)
+	for _, p := range h.Ports {
+		ports[p.Port] = p
+	}
+	return ports
+}
+
+// WriteDiff writes the changes (as returned by Diff) in human-readable form,
+// listing the changed ports on each host.
+func WriteDiff(w io.Writer, changes []Change) error {
+	var buf bytes.Buffer
+	if len(changes) == 0 {
+		fmt.Fprintln(&buf, "No changes.")
+	}
+	for i, c := range changes {
+		if i == 0 || c.Addr != changes[i-1].Addr {
+			h := Host{Name: c.Name, Addr: c.Addr}
+			fmt.Fprintf(&buf, "Changed %s ports on %v:\n", c.Protocol,
+				&h)
+		}
+		fmt.Fprint(&buf, c.Port)
+		if c.Service != "" {
+			fmt.Fprintf(&buf, " (%s)", c.Service)
+		}
+		fmt.Fprintf(&buf, ": %s\n", c.Description())
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Description returns a human-readable description of the change (e.g.,
+// "opened").
+func (c *Change) Description() string {
+	switch c.Kind {
+	case Changed:
+		return fmt.Sprintf("service changed from \"%s\" to \"%s\"",
+			c.Old, c.New)
+	case StateChanged:
+		return fmt.Sprintf("state changed from %s to %s", c.Old, c.New)
+	}
+	return string(c.Kind)
+}
@@ -0,0 +1,152 @@
+// Unit tests for the comparison of reports of package report.
+package report
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/portprobe"
+	"github.com/webbnh/DigitalOcean/servprobe"
+)
+
+// newDiffScans returns the reports of two scans of the same hosts, with
+// changes.
+func newDiffScans() (*Scan, *Scan) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	detected := func(p Port, version string) Port {
+		p.Detected = &servprobe.Service{Name: "ssh",
+			Product: "OpenSSH", Version: version}
+		return p
+	}
+
+	before := New("webbscan", "tcp",
+		[]int{22, 65001, 65002, 65003, 65004, 65006, 65007}, start)
+	h := before.AddHost("web", "192.0.2.1")
+	before.AddPort(h, detected(NewPort("tcp", 22, portprobe.Open), "9.6"))
+	before.Add(h, 65001, portprobe.Open)
+	before.Add(h, 65002, portprobe.Closed)
+	before.Add(h, 65004, portprobe.Open)
+	before.Add(h, 65006, portprobe.Open)
+	before.Add(h, 65007, portprobe.OpenFiltered)
+	h = before.AddHost("", "192.0.2.2")
+	before.Add(h, 65001, portprobe.Open)
+	h = before.AddHost("", "192.0.2.9")
+	before.Add(h, 65001, portprobe.Open)
+
+	after := New("webbscan", "tcp",
+		[]int{22, 65001, 65002, 65003, 65005, 65006, 65007},
+		start.Add(24*time.Hour))
+	h = after.AddHost("web", "192.0.2.1")
+	after.AddPort(h, detected(NewPort("tcp", 22, portprobe.Open), "9.7"))
+	after.Add(h, 65001, portprobe.Closed)
+	after.Add(h, 65002, portprobe.Open)
+	after.Add(h, 65003, portprobe.OpenFiltered)
+	after.Add(h, 65005, portprobe.Open)
+	after.Add(h, 65006, portprobe.OpenFiltered)
+	after.Add(h, 65007, portprobe.Open)
+	h = after.AddHost("", "192.0.2.2")
+	after.Add(h, 65001, portprobe.Open)
+	h = after.AddHost("", "192.0.2.3")
+	after.Add(h, 65001, portprobe.Open)
+
+	return before, after
+}
+
+func TestDiff(t *testing.T) {
+	before, after := newDiffScans()
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatalf("Diff() returned \"%v\".\n", err)
+	}
+
+	web := func(port int, kind ChangeKind) Change {
+		return Change{Name: "web", Addr: "192.0.2.1", Port: port,
+			Protocol: "tcp", Service: Service("tcp", port),
+			Kind: kind}
+	}
+	changed := web(22, Changed)
+	changed.Old, changed.New = "ssh OpenSSH 9.6", "ssh OpenSSH 9.7"
+	restated := web(65006, StateChanged)
+	restated.Old, restated.New = "open", "open|filtered"
+	reopened := web(65007, StateChanged)
+	reopened.Old, reopened.New = "open|filtered", "open"
+	expected := []Change{changed, web(65001, Closed), web(65002, Opened),
+		restated, reopened}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Diff() returned %+v; expected %+v.\n", changes,
+			expected)
+	}
+
+	changes, err = Diff(after, after)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("Diff() of a scan with itself returned %+v, "+
+			"\"%v\".\n", changes, err)
+	}
+
+	after.Protocol = "udp"
+	if _, err := Diff(before, after); err == nil {
+		t.Error("Diff() of scans using different protocols " +
+			"unexpectedly succeeded.")
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	changes := []Change{
+		{Name: "web", Addr: "192.0.2.1", Port: 22, Protocol: "tcp",
+			Service: "ssh", Kind: Changed, Old: "ssh OpenSSH 9.6",
+			New: "ssh OpenSSH 9.7"},
+		{Name: "web", Addr: "192.0.2.1", Port: 65001, Protocol: "tcp",
+			Kind: Closed},
+		{Name: "web", Addr: "192.0.2.1", Port: 65006, Protocol: "tcp",
+			Kind: StateChanged, Old: "open", New: "error"},
+		{Addr: "192.0.2.2", Port: 80, Protocol: "tcp",
+			Service: "http", Kind: Opened},
+	}
+
+	cases := []struct {
+		changes  []Change
+		expected string
+	}{
+		{nil, "No changes.\n"},
+		{changes, "Changed tcp ports on web (192.0.2.1):\n" +
+			"22 (ssh): service changed from \"ssh OpenSSH 9.6\" " +
+			"to \"ssh OpenSSH 9.7\"\n" +
+			"65001: closed\n" +
+			"65006: state changed from open to error\n" +
+			"Changed tcp ports on 192.0.2.2:\n" +
+			"80 (http): opened\n"},
+	}
+
+	for _, v := range cases {
+		var buf bytes.Buffer
+		if err := WriteDiff(&buf, v.changes); err != nil {
+			t.Fatalf("WriteDiff() returned \"%v\".\n", err)
+		}
+		if buf.String() != v.expected {
+			t.Errorf("Got:\n%s\nexpected:\n%s\n", buf.String(),
+				v.expected)
+		}
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	s := newTestScan()
+	var buf bytes.Buffer
+	if err := s.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON() returned \"%v\".\n", err)
+	}
+
+	got, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatalf("ReadJSON() returned \"%v\".\n", err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Errorf("ReadJSON() returned %+v; expected %+v.\n", got, s)
+	}
+
+	if _, err := ReadJSON(bytes.NewBufferString("{")); err == nil {
+		t.Error("ReadJSON() of invalid JSON unexpectedly succeeded.")
+	}
+}
@@ -0,0 +1,417 @@
+// Package report provides a representation of the results of a port scan and
+// support for writing it in various formats.
+//
+// The JSON form of a report is a single object with the following members:
+//
+//	"scanner":     the name of the tool which produced the report
+//	"start":       the time at which the scan started (RFC 3339)
+//	"end":         the time at which the scan finished (RFC 3339)
+//	"elapsed":     the duration of the scan, in seconds
+//	"protocol":    the protocol which was probed ("tcp" or "udp")
+//	"complete":    false if the scan was interrupted before all of the
+//	               probes were performed
+//	"flags":       an object mapping each command line flag to its value
+//	"ports":       an array of the port numbers which were probed on each
+//	               host
+//	"probes":      the number of probes performed
+//	"probe_rate":  the average number of probes per second
+//	"hosts":       an array of host objects
+//
+// Each host object has the following members:
+//
+//	"name":     the name by which the host was specified
+//	"address":  the IP address of the host
+//	"closed":   the number of ports which were found to be closed
+//	"filtered": the number of ports which were found to be filtered
+//	"ports":    an array of port objects, one for each port which was not
+//	            found to be closed or filtered
+//
+// Each port object has the following members:
+//
+//	"port":      the port number
+//	"protocol":  the protocol which was probed
+//	"state":     the result of the probe ("open", "open|filtered", or
+//	             "error")
+//	"reason":    the reason for the result, if known ("refused",
+//	             "timeout", "unreachable", "permission", "response",
+//	             "confirmed" (a valid reply in the protocol of the
+//	             service expected on the port), or "other")
+//	"attempts":  the number of attempts which were made to probe the port,
+//	             if known
+//	"banner":    the first data sent by the service, if banners were
+//	             requested and the service sent any (with non-printable
+//	             characters escaped, as by Sanitize)
+//	"detected":  the service detected on the port, if detection was
+//	             requested and the service was identified:  an object
+//	             with the members "name", "product", "version", and
+//	             "info" (all but the first are omitted if unknown)
+//	"service":   the name of the service conventionally assigned to the
+//	             port, if any
+//	"tls":       the TLS session negotiated with the service, if TLS
+//	             inspection was requested and the service speaks TLS:  an
+//	             object with the members "version", "cipher_suite",
+//	             "alpn" (omitted if none was selected), and
+//	             "certificates", an array of certificate objects, starting
+//	             with the service's own
+//	"http":      the response of the web server to a request for its root
+//	             page, if HTTP fingerprinting was requested and the service
+//	             speaks HTTP:  an object with the members "url", "status",
+//	             "server", "title", "location" (the last three are
+//	             omitted if absent), and "body_sha256" (the SHA-256 hash of
+//	             the body, in hexadecimal)
+//	"ssh":       the description of the SSH server, if SSH fingerprinting
+//	             was requested and the service speaks SSH:  an object with
+//	             the members "version" (the server's identification
+//	             string), "kex_algorithms", "host_key_algorithms",
+//	             "ciphers", "macs", "compression" (arrays of the
+//	             algorithms supported by the server), "host_key_type", and
+//	             "host_key_sha256" (the fingerprint of the host key, as
+//	             reported by OpenSSH); all but the first are omitted if the
+//	             exchange with the server did not get far enough to learn
+//	             them
+//
+// Each certificate object has the following members:
+//
+//	"subject":     the distinguished name of the subject
+//	"sans":        an array of the subject alternative names, if any
+//	"issuer":      the distinguished name of the issuer
+//	"not_before":  the start of the validity period (RFC 3339)
+//	"not_after":   the end of the validity period (RFC 3339)
+//	"expiring":    true if the certificate has expired or will expire
+//	               within the warning period (omitted otherwise)
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/httpprobe"
+	"github.com/webbnh/DigitalOcean/portprobe"
+	"github.com/webbnh/DigitalOcean/portserv"
+	"github.com/webbnh/DigitalOcean/servprobe"
+	"github.com/webbnh/DigitalOcean/sshprobe"
+	"github.com/webbnh/DigitalOcean/tlsprobe"
+)
+
+// Scan represents the results of a port scan of one or more hosts.
+type Scan struct {
+	Scanner   string            `json:"scanner"`
+	Start     time.Time         `json:"start"`
+	End       time.Time         `json:"end"`
+	Elapsed   float64           `json:"elapsed"`
+	Protocol  string            `json:"protocol"`
+	Complete  bool              `json:"complete"`
+	Flags     map[string]string `json:"flags,omitempty"`
+	Ports     []int             `json:"ports"`
+	Probes    int               `json:"probes"`
+	ProbeRate float64           `json:"probe_rate"`
+	Hosts     []*Host           `json:"hosts"`
+}
+
+// Host represents the results of a port scan of a single host.
+type Host struct {
+	Name     string `json:"name"`
+	Addr     string `json:"address"`
+	Closed   int    `json:"closed"`
+	Filtered int    `json:"filtered"`
+	Ports    []Port `json:"ports"`
+}
+
+// Port represents the result of the probe of a single port.
+type Port struct {
+	Port     int                `json:"port"`
+	Protocol string             `json:"protocol"`
+	State    portprobe.Result   `json:"state"`
+	Reason   portprobe.Reason   `json:"reason,omitempty"`
+	Attempts int                `json:"attempts,omitempty"`
+	Service  string             `json:"service,omitempty"`
+	Banner   string             `json:"banner,omitempty"`
+	Detected *servprobe.Service `json:"detected,omitempty"`
+	TLS      *tlsprobe.Info     `json:"tls,omitempty"`
+	HTTP     *httpprobe.Info    `json:"http,omitempty"`
+	SSH      *sshprobe.Info     `json:"ssh,omitempty"`
+}
+
+// New creates a new, empty report for a scan of the specified ports using the
+// specified protocol which started at the specified time.
+func New(scanner, protocol string, ports []int, start time.Time) *Scan {
+	return &Scan{
+		Scanner:  scanner,
+		Start:    start,
+		Protocol: protocol,
+		Complete: true,
+		Ports:    ports,
+		Hosts:    []*Host{},
+	}
+}
+
+// AddHost adds a host to the report and returns it so that port results can
+// be added to it.
+func (s *Scan) AddHost(name, addr string) *Host {
+	h := &Host{Name: name, Addr: addr, Ports: []Port{}}
+	s.Hosts = append(s.Hosts, h)
+	return h
+}
+
+// Add records the result of a probe of the specified port of the host.  Only
+// ports which are not closed or filtered are recorded individually, but every
+// probe is counted.
+func (s *Scan) Add(h *Host, port int, result portprobe.Result) {
+	s.AddPort(h, NewPort(s.Protocol, port, result))
+}
+
+// AddPort is like Add, but it records a port which has already been
+// constructed (e.g., one with a banner).
+func (s *Scan) AddPort(h *Host, p Port) {
+	s.Probes++
+	switch {
+	case p.State.IsClosed():
+		h.Closed++
+		return
+	case p.State.IsFiltered() && !p.State.IsOpenFiltered():
+		h.Filtered++
+		return
+	}
+	h.Ports = append(h.Ports, p)
+}
+
+// NewPort returns the representation of the result of the probe of the
+// specified port using the specified protocol.  (The reason for the result
+// and the number of attempts, if known, are left for the caller to set.)
+func NewPort(protocol string, port int, result portprobe.Result) Port {
+	return Port{
+		Port:     port,
+		Protocol: protocol,
+		State:    result,
+		Service:  Service(protocol, port),
+	}
+}
+
+// Sanitize returns the data sent by a service (e.g., a banner) as a string
+// which is safe to display:  trailing white space is removed, and characters
+// which are not printable ASCII (and backslashes) are escaped, as in a Go
+// string literal (e.g., "\r\n" or "\x00").
+func Sanitize(data []byte) string {
+	data = bytes.TrimRight(data, " \t\r\n\x00")
+
+	var sb strings.Builder
+	for _, c := range data {
+		switch {
+		case c == '\\':
+			sb.WriteString(`\\`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c >= ' ' && c <= '~':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		}
+	}
+	return sb.String()
+}
+
+// Finish records the time at which the scan finished, computes the summary
+// statistics, and puts the ports of each host in order (since the results of
+// the probes may have been added in any order).
+func (s *Scan) Finish(end time.Time) {
+	for _, h := range s.Hosts {
+		sort.Slice(h.Ports, func(i, j int) bool {
+			return h.Ports[i].Port < h.Ports[j].Port
+		})
+	}
+
+	s.End = end
+	elapsed := end.Sub(s.Start)
+	s.Elapsed = elapsed.Seconds()
+	if elapsed > 0 {
+		s.ProbeRate = float64(s.Probes) / elapsed.Seconds()
+	}
+}
+
+// String returns the host as a string, showing the name, if there is one,
+// and the address.
+func (h *Host) String() string {
+	if h.Name == "" || h.Name == h.Addr {
+		return h.Addr
+	}
+	return fmt.Sprintf("%s (%s)", h.Name, h.Addr)
+}
+
+// Service returns the name of the service conventionally assigned to the
+// specified port for the specified protocol, or an empty string if there is
+// none.
+func Service(protocol string, port int) string {
+	switch protocol {
+	case "tcp":
+		return portserv.Tcp(port)
+	case "udp":
+		return portserv.Udp(port)
+	}
+	return ""
+}
+
+// WriteText writes the report in human-readable form, listing the open ports
+// on each host, with the service detected on each, if any (and any ports
+// which might be open, which couldn't be probed, or which were confirmed to
+// be open by a valid reply, annotated with their status, as well as any ports
+// which required more than one attempt), followed by the banner of each, if
+// any, and a description of the TLS session and certificates, of the web
+// server, and of the SSH server of each, if any.
+func (s *Scan) WriteText(w io.Writer) error {
+	var buf bytes.Buffer
+	for _, h := range s.Hosts {
+		if len(h.Ports) == 0 {
+			fmt.Fprintf(&buf, "No open %s ports on %v.\n",
+				s.Protocol, h)
+			continue
+		}
+
+		fmt.Fprintf(&buf, "Open %s ports on %v:\n", s.Protocol, h)
+		for _, p := range h.Ports {
+			fmt.Fprint(&buf, p.Port)
+			if p.Service != "" {
+				fmt.Fprintf(&buf, " (%s)", p.Service)
+			}
+			if p.Detected != nil {
+				fmt.Fprintf(&buf, ": %v", p.Detected)
+			}
+			switch {
+			case p.State.IsOpen() &&
+				p.Reason != portprobe.ReasonConfirmed:
+			case p.Reason != portprobe.ReasonNone:
+				fmt.Fprintf(&buf, " [%v: %v]", p.State, p.Reason)
+			default:
+				fmt.Fprintf(&buf, " [%v]", p.State)
+			}
+			if p.Attempts > 1 {
+				fmt.Fprintf(&buf, " (%d attempts)", p.Attempts)
+			}
+			fmt.Fprintln(&buf)
+			if p.Banner != "" {
+				fmt.Fprintf(&buf, "    %s\n", p.Banner)
+			}
+			if p.TLS != nil {
+				writeTLS(&buf, p.TLS)
+			}
+			if p.HTTP != nil {
+				writeHTTP(&buf, p.HTTP)
+			}
+			if p.SSH != nil {
+				writeSSH(&buf, p.SSH)
+			}
+		}
+	}
+	if !s.Complete {
+		fmt.Fprintln(&buf, "(The scan was interrupted; "+
+			"the results are incomplete.)")
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// writeTLS writes a description of the TLS session and of each certificate in
+// the chain, flagging any which have expired or will expire soon.
+func writeTLS(buf *bytes.Buffer, info *tlsprobe.Info) {
+	fmt.Fprintf(buf, "    TLS:  %s, %s", info.Version, info.CipherSuite)
+	if info.ALPN != "" {
+		fmt.Fprintf(buf, ", ALPN %s", info.ALPN)
+	}
+	fmt.Fprintln(buf)
+	for i, c := range info.Certificates {
+		fmt.Fprintf(buf, "    Certificate %d:  %s\n", i, c.Subject)
+		if len(c.SANs) > 0 {
+			fmt.Fprintf(buf, "      SANs:  %s\n",
+				strings.Join(c.SANs, ", "))
+		}
+		fmt.Fprintf(buf, "      Issuer:  %s\n", c.Issuer)
+		fmt.Fprintf(buf, "      Expires:  %s",
+			c.NotAfter.Format(time.RFC3339))
+		if c.Expiring {
+			fmt.Fprint(buf, " [expiring]")
+		}
+		fmt.Fprintln(buf)
+	}
+}
+
+// writeHTTP writes a description of the response of a web server.
+func writeHTTP(buf *bytes.Buffer, info *httpprobe.Info) {
+	fmt.Fprintf(buf, "    HTTP:  %s %d\n", info.URL, info.Status)
+	fields := []struct{ name, value string }{
+		{"Server", info.Server},
+		{"Title", info.Title},
+		{"Location", info.Location},
+		{"Body SHA-256", info.BodyHash},
+	}
+	for _, f := range fields {
+		if f.value != "" {
+			fmt.Fprintf(buf, "      %s:  %s\n", f.name, f.value)
+		}
+	}
+}
+
+// writeSSH writes a description of an SSH server.
+func writeSSH(buf *bytes.Buffer, info *sshprobe.Info) {
+	fmt.Fprintf(buf, "    SSH:  %s\n", info.Version)
+	if info.HostKeySHA256 != "" {
+		fmt.Fprintf(buf, "      Host key:  %s %s\n", info.HostKeyType,
+			info.HostKeySHA256)
+	}
+	fields := []struct {
+		name  string
+		value []string
+	}{
+		{"Key exchange", info.KexAlgorithms},
+		{"Host key algorithms", info.HostKeyAlgorithms},
+		{"Ciphers", info.Ciphers},
+		{"MACs", info.MACs},
+		{"Compression", info.Compression},
+	}
+	for _, f := range fields {
+		if len(f.value) > 0 {
+			fmt.Fprintf(buf, "      %s:  %s\n", f.name,
+				strings.Join(f.value, ", "))
+		}
+	}
+}
+
+// WriteJSON writes the report as a JSON document.
+func (s *Scan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// ReadJSON reads a report in the form written by WriteJSON.
+func ReadJSON(r io.Reader) (*Scan, error) {
+	s := &Scan{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ReadFile reads a report in the form written by WriteJSON from the specified
+// file.
+func ReadFile(name string) (*Scan, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := ReadJSON(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return s, nil
+}
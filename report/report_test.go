@@ -0,0 +1,324 @@
+// Unit tests for package report.
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/httpprobe"
+	"github.com/webbnh/DigitalOcean/portprobe"
+	"github.com/webbnh/DigitalOcean/servprobe"
+	"github.com/webbnh/DigitalOcean/sshprobe"
+	"github.com/webbnh/DigitalOcean/tlsprobe"
+)
+
+// newTestScan returns a report for two hosts, one of which has open ports.
+func newTestScan() *Scan {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := New("webbscan", "tcp", []int{21, 22, 65000}, start)
+	s.Flags = map[string]string{"agents": "8"}
+
+	h := s.AddHost("web", "192.0.2.1")
+	s.Add(h, 21, portprobe.Closed)
+	s.Add(h, 65000, portprobe.Open)
+	p := NewPort("tcp", 22, portprobe.Open)
+	p.Banner = "SSH-2.0-OpenSSH_9.6"
+	p.Detected = &servprobe.Service{Name: "ssh", Product: "OpenSSH",
+		Version: "9.6", Info: "protocol 2.0"}
+	s.AddPort(h, p)
+
+	h = s.AddHost("192.0.2.2", "192.0.2.2")
+	s.Add(h, 22, portprobe.Closed)
+	p = NewPort("tcp", 23, portprobe.Filtered)
+	p.Reason = portprobe.ReasonTimeout
+	s.AddPort(h, p)
+	p = NewPort("tcp", 24, portprobe.OpenFiltered)
+	p.Reason, p.Attempts = portprobe.ReasonTimeout, 3
+	s.AddPort(h, p)
+
+	s.Finish(start.Add(2 * time.Second))
+	return s
+}
+
+func TestAdd(t *testing.T) {
+	s := newTestScan()
+
+	if s.Probes != 6 {
+		t.Errorf("Probes is %d; expected 6.\n", s.Probes)
+	}
+	if len(s.Hosts) != 2 {
+		t.Fatalf("Got %d hosts; expected 2.\n", len(s.Hosts))
+	}
+	if len(s.Hosts[0].Ports) != 2 || len(s.Hosts[1].Ports) != 1 {
+		t.Errorf("Got %d and %d ports; expected 2 and 1.\n",
+			len(s.Hosts[0].Ports), len(s.Hosts[1].Ports))
+	}
+	if s.Hosts[0].Closed != 1 || s.Hosts[1].Closed != 1 {
+		t.Errorf("Got %d and %d closed ports; expected 1 and 1.\n",
+			s.Hosts[0].Closed, s.Hosts[1].Closed)
+	}
+	if s.Hosts[0].Filtered != 0 || s.Hosts[1].Filtered != 1 {
+		t.Errorf("Got %d and %d filtered ports; expected 0 and 1.\n",
+			s.Hosts[0].Filtered, s.Hosts[1].Filtered)
+	}
+	if p := s.Hosts[1].Ports[0]; p.Port != 24 || !p.State.IsOpenFiltered() ||
+		p.Reason != portprobe.ReasonTimeout || p.Attempts != 3 {
+		t.Errorf("Got unexpected port %+v.\n", p)
+	}
+	if p := s.Hosts[0].Ports[0]; p.Port != 22 || p.Protocol != "tcp" ||
+		p.State != portprobe.Open || p.Service != Service("tcp", 22) ||
+		p.Banner != "SSH-2.0-OpenSSH_9.6" {
+		t.Errorf("Got unexpected port %+v.\n", p)
+	}
+}
+
+func TestFinish(t *testing.T) {
+	s := newTestScan()
+
+	if s.Elapsed != 2 {
+		t.Errorf("Elapsed is %v; expected 2.\n", s.Elapsed)
+	}
+	if s.ProbeRate != 3 {
+		t.Errorf("ProbeRate is %v; expected 3.\n", s.ProbeRate)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	s := newTestScan()
+	s.Hosts[0].Ports[0].Service = "ssh"
+
+	var buf bytes.Buffer
+	if err := s.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() returned \"%v\".\n", err)
+	}
+
+	expected := "Open tcp ports on web (192.0.2.1):\n" +
+		"22 (ssh): ssh OpenSSH 9.6 (protocol 2.0)\n" +
+		"    SSH-2.0-OpenSSH_9.6\n" +
+		"65000\n" +
+		"Open tcp ports on 192.0.2.2:\n" +
+		"24 [open|filtered: timeout] (3 attempts)\n"
+	if buf.String() != expected {
+		t.Errorf("Got:\n%s\nexpected:\n%s\n", buf.String(), expected)
+	}
+
+	// An incomplete scan is noted as such.
+	s.Complete = false
+	buf.Reset()
+	if err := s.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() returned \"%v\".\n", err)
+	}
+	expected += "(The scan was interrupted; the results are incomplete.)\n"
+	if buf.String() != expected {
+		t.Errorf("Got:\n%s\nexpected:\n%s\n", buf.String(), expected)
+	}
+
+	// A port which was confirmed to be open is annotated as such.
+	s = New("webbscan", "udp", []int{65053}, s.Start)
+	p := NewPort("udp", 65053, portprobe.Open)
+	p.Reason = portprobe.ReasonConfirmed
+	s.AddPort(s.AddHost("ns", "192.0.2.3"), p)
+	buf.Reset()
+	if err := s.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() returned \"%v\".\n", err)
+	}
+	expected = "Open udp ports on ns (192.0.2.3):\n" +
+		"65053 [open: confirmed]\n"
+	if buf.String() != expected {
+		t.Errorf("Got:\n%s\nexpected:\n%s\n", buf.String(), expected)
+	}
+}
+
+// newTestTLS returns the description of a TLS session with a certificate
+// which is expiring.
+func newTestTLS() *tlsprobe.Info {
+	return &tlsprobe.Info{
+		Version:     "TLS 1.3",
+		CipherSuite: "TLS_AES_128_GCM_SHA256",
+		ALPN:        "h2",
+		Certificates: []tlsprobe.Certificate{{
+			Subject:   "CN=example.com",
+			SANs:      []string{"example.com", "www.example.com"},
+			Issuer:    "CN=Example CA",
+			NotBefore: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			Expiring:  true,
+		}, {
+			Subject:   "CN=Example CA",
+			Issuer:    "CN=Example Root",
+			NotBefore: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			NotAfter:  time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		}},
+	}
+}
+
+func TestWriteTextTLS(t *testing.T) {
+	s := New("webbscan", "tcp", []int{65443}, time.Now())
+	p := NewPort("tcp", 65443, portprobe.Open)
+	p.TLS = newTestTLS()
+	s.AddPort(s.AddHost("", "192.0.2.1"), p)
+
+	var buf bytes.Buffer
+	if err := s.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() returned \"%v\".\n", err)
+	}
+	expected := "Open tcp ports on 192.0.2.1:\n" +
+		"65443\n" +
+		"    TLS:  TLS 1.3, TLS_AES_128_GCM_SHA256, ALPN h2\n" +
+		"    Certificate 0:  CN=example.com\n" +
+		"      SANs:  example.com, www.example.com\n" +
+		"      Issuer:  CN=Example CA\n" +
+		"      Expires:  2026-01-01T00:00:00Z [expiring]\n" +
+		"    Certificate 1:  CN=Example CA\n" +
+		"      Issuer:  CN=Example Root\n" +
+		"      Expires:  2030-01-01T00:00:00Z\n"
+	if buf.String() != expected {
+		t.Errorf("Got:\n%s\nexpected:\n%s\n", buf.String(), expected)
+	}
+}
+
+func TestWriteTextHTTP(t *testing.T) {
+	s := New("webbscan", "tcp", []int{65080}, time.Now())
+	p := NewPort("tcp", 65080, portprobe.Open)
+	p.HTTP = &httpprobe.Info{URL: "http://192.0.2.1:65080/", Status: 301,
+		Server: "nginx/1.24.0", Location: "https://example.com/",
+		BodyHash: "e3b0c442"}
+	s.AddPort(s.AddHost("", "192.0.2.1"), p)
+
+	var buf bytes.Buffer
+	if err := s.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() returned \"%v\".\n", err)
+	}
+	expected := "Open tcp ports on 192.0.2.1:\n" +
+		"65080\n" +
+		"    HTTP:  http://192.0.2.1:65080/ 301\n" +
+		"      Server:  nginx/1.24.0\n" +
+		"      Location:  https://example.com/\n" +
+		"      Body SHA-256:  e3b0c442\n"
+	if buf.String() != expected {
+		t.Errorf("Got:\n%s\nexpected:\n%s\n", buf.String(), expected)
+	}
+}
+
+func TestWriteTextSSH(t *testing.T) {
+	s := New("webbscan", "tcp", []int{65022}, time.Now())
+	p := NewPort("tcp", 65022, portprobe.Open)
+	p.SSH = newTestSSH()
+	s.AddPort(s.AddHost("", "192.0.2.1"), p)
+
+	var buf bytes.Buffer
+	if err := s.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() returned \"%v\".\n", err)
+	}
+	expected := "Open tcp ports on 192.0.2.1:\n" +
+		"65022\n" +
+		"    SSH:  SSH-2.0-OpenSSH_9.6\n" +
+		"      Host key:  ssh-ed25519 SHA256:FYudkqDz\n" +
+		"      Key exchange:  curve25519-sha256, ecdh-sha2-nistp256\n" +
+		"      Host key algorithms:  ssh-ed25519\n" +
+		"      Ciphers:  aes128-ctr\n" +
+		"      MACs:  hmac-sha2-256\n" +
+		"      Compression:  none\n"
+	if buf.String() != expected {
+		t.Errorf("Got:\n%s\nexpected:\n%s\n", buf.String(), expected)
+	}
+}
+
+// newTestSSH returns the description of an SSH server.
+func newTestSSH() *sshprobe.Info {
+	return &sshprobe.Info{
+		Version: "SSH-2.0-OpenSSH_9.6",
+		KexAlgorithms: []string{"curve25519-sha256",
+			"ecdh-sha2-nistp256"},
+		HostKeyAlgorithms: []string{"ssh-ed25519"},
+		Ciphers:           []string{"aes128-ctr"},
+		MACs:              []string{"hmac-sha2-256"},
+		Compression:       []string{"none"},
+		HostKeyType:       "ssh-ed25519",
+		HostKeySHA256:     "SHA256:FYudkqDz",
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	s := newTestScan()
+
+	var buf bytes.Buffer
+	if err := s.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON() returned \"%v\".\n", err)
+	}
+
+	var doc struct {
+		Scanner   string
+		Start     string
+		Elapsed   float64
+		Protocol  string
+		Complete  bool
+		Flags     map[string]string
+		Probes    int
+		ProbeRate float64 `json:"probe_rate"`
+		Hosts     []struct {
+			Name    string
+			Address string
+			Ports   []struct {
+				Port     int
+				Protocol string
+				State    string
+				Reason   string
+				Attempts int
+				Banner   string
+				Detected struct {
+					Name    string
+					Product string
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Unable to decode JSON:  %v.\n", err)
+	}
+
+	if doc.Scanner != "webbscan" || doc.Start != "2020-01-02T03:04:05Z" ||
+		doc.Elapsed != 2 || doc.Protocol != "tcp" || !doc.Complete ||
+		doc.Flags["agents"] != "8" || doc.Probes != 6 ||
+		doc.ProbeRate != 3 {
+		t.Errorf("Got unexpected scan metadata %+v.\n", doc)
+	}
+	if len(doc.Hosts) != 2 || doc.Hosts[0].Address != "192.0.2.1" ||
+		len(doc.Hosts[0].Ports) != 2 || len(doc.Hosts[1].Ports) != 1 {
+		t.Fatalf("Got unexpected hosts %+v.\n", doc.Hosts)
+	}
+	if p := doc.Hosts[0].Ports[0]; p.Banner != "SSH-2.0-OpenSSH_9.6" ||
+		p.Detected.Name != "ssh" || p.Detected.Product != "OpenSSH" {
+		t.Errorf("Got unexpected port %+v.\n", p)
+	}
+	if p := doc.Hosts[0].Ports[1]; p.Port != 65000 ||
+		p.Protocol != "tcp" || p.State != "open" || p.Reason != "" {
+		t.Errorf("Got unexpected port %+v.\n", p)
+	}
+	if p := doc.Hosts[1].Ports[0]; p.State != "open|filtered" ||
+		p.Reason != "timeout" || p.Attempts != 3 {
+		t.Errorf("Got unexpected port %+v.\n", p)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	cases := []struct {
+		data     string
+		expected string
+	}{
+		{"", ""},
+		{"SSH-2.0-OpenSSH_9.6\r\n", "SSH-2.0-OpenSSH_9.6"},
+		{"220-Welcome\r\n220 Ready\r\n", `220-Welcome\r\n220 Ready`},
+		{"J\x00\x00\x00\n8.0.36\x00", `J\x00\x00\x00\n8.0.36`},
+		{"tab\there \\ \xff", `tab\there \\ \xff`},
+	}
+
+	for _, v := range cases {
+		if got := Sanitize([]byte(v.data)); got != v.expected {
+			t.Errorf("Sanitize(%q) returned %q; expected %q.\n",
+				v.data, got, v.expected)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/portprobe"
+)
+
+// Event represents the result of a single probe, as written to a stream.  Its
+// JSON form is an object with the members of a port object (see above) plus
+// the following:
+//
+//	"time":     the time at which the probe completed (RFC 3339)
+//	"name":     the name by which the host was specified
+//	"address":  the IP address of the host
+type Event struct {
+	Time time.Time `json:"time"`
+	Name string    `json:"name"`
+	Addr string    `json:"address"`
+	Port
+}
+
+// Stream writes the results of individual probes as they complete, as
+// newline-delimited JSON (one Event object per line).
+type Stream struct {
+	enc *json.Encoder
+	// Source of the event time stamps (overridden for testing)
+	now func() time.Time
+}
+
+// NewStream creates a new Stream which writes to the specified Writer.
+func NewStream(w io.Writer) *Stream {
+	return &Stream{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Write writes the result of the probe of the specified port on the specified
+// host to the stream.
+func (st *Stream) Write(name, addr, protocol string, port int,
+	result portprobe.Result) error {
+	return st.WritePort(name, addr, NewPort(protocol, port, result))
+}
+
+// WritePort is like Write, but it writes a port which has already been
+// constructed (e.g., one with a banner).
+func (st *Stream) WritePort(name, addr string, p Port) error {
+	return st.enc.Encode(Event{
+		Time: st.now(),
+		Name: name,
+		Addr: addr,
+		Port: p,
+	})
+}
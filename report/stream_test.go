@@ -0,0 +1,47 @@
+// Unit tests for the streaming output of package report.
+package report
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/portprobe"
+)
+
+func TestStream(t *testing.T) {
+	var buf bytes.Buffer
+	st := NewStream(&buf)
+	st.now = func() time.Time {
+		return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	}
+
+	err := st.Write("web", "192.0.2.1", "tcp", 65000, portprobe.Open)
+	if err != nil {
+		t.Fatalf("Write() returned \"%v\".\n", err)
+	}
+	err = st.Write("db", "192.0.2.2", "udp", 65001, portprobe.Closed)
+	if err != nil {
+		t.Fatalf("Write() returned \"%v\".\n", err)
+	}
+
+	p := NewPort("tcp", 22, portprobe.Open)
+	p.Banner = "SSH-2.0-OpenSSH_9.6"
+	if err := st.WritePort("web", "192.0.2.1", p); err != nil {
+		t.Fatalf("WritePort() returned \"%v\".\n", err)
+	}
+
+	expected := `{"time":"2020-01-02T03:04:05Z","name":"web",` +
+		`"address":"192.0.2.1","port":65000,"protocol":"tcp",` +
+		`"state":"open"}` + "\n" +
+		`{"time":"2020-01-02T03:04:05Z","name":"db",` +
+		`"address":"192.0.2.2","port":65001,"protocol":"udp",` +
+		`"state":"closed"}` + "\n" +
+		`{"time":"2020-01-02T03:04:05Z","name":"web",` +
+		`"address":"192.0.2.1","port":22,"protocol":"tcp",` +
+		`"state":"open","service":"ssh",` +
+		`"banner":"SSH-2.0-OpenSSH_9.6"}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Got:\n%s\nexpected:\n%s\n", buf.String(), expected)
+	}
+}
@@ -0,0 +1,392 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/httpprobe"
+	"github.com/webbnh/DigitalOcean/portprobe"
+	"github.com/webbnh/DigitalOcean/sshprobe"
+	"github.com/webbnh/DigitalOcean/tlsprobe"
+)
+
+// The structures below mirror the subset of nmap's XML output format (see
+// https://nmap.org/book/nmap-dtd.html) which is needed to represent a report.
+
+type xmlNmapRun struct {
+	XMLName          xml.Name    `xml:"nmaprun"`
+	Scanner          string      `xml:"scanner,attr"`
+	Args             string      `xml:"args,attr"`
+	Start            int64       `xml:"start,attr"`
+	StartStr         string      `xml:"startstr,attr"`
+	Version          string      `xml:"version,attr"`
+	XMLOutputVersion string      `xml:"xmloutputversion,attr"`
+	ScanInfo         xmlScanInfo `xml:"scaninfo"`
+	Hosts            []xmlHost   `xml:"host"`
+	RunStats         xmlRunStats `xml:"runstats"`
+}
+
+type xmlScanInfo struct {
+	Type        string `xml:"type,attr"`
+	Protocol    string `xml:"protocol,attr"`
+	NumServices int    `xml:"numservices,attr"`
+	Services    string `xml:"services,attr"`
+}
+
+type xmlHost struct {
+	StartTime int64         `xml:"starttime,attr"`
+	EndTime   int64         `xml:"endtime,attr"`
+	Status    xmlStatus     `xml:"status"`
+	Address   xmlAddress    `xml:"address"`
+	Hostnames []xmlHostname `xml:"hostnames>hostname"`
+	Ports     xmlPorts      `xml:"ports"`
+}
+
+type xmlStatus struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr"`
+}
+
+type xmlAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+}
+
+type xmlHostname struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type xmlPorts struct {
+	ExtraPorts []xmlExtraPorts `xml:"extraports"`
+	Ports      []xmlPort       `xml:"port"`
+}
+
+type xmlExtraPorts struct {
+	State string `xml:"state,attr"`
+	Count int    `xml:"count,attr"`
+}
+
+type xmlPort struct {
+	Protocol string      `xml:"protocol,attr"`
+	PortID   int         `xml:"portid,attr"`
+	State    xmlState    `xml:"state"`
+	Service  *xmlService `xml:"service"`
+	Scripts  []xmlScript `xml:"script"`
+}
+
+type xmlState struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr"`
+}
+
+type xmlService struct {
+	Name      string `xml:"name,attr"`
+	Product   string `xml:"product,attr,omitempty"`
+	Version   string `xml:"version,attr,omitempty"`
+	ExtraInfo string `xml:"extrainfo,attr,omitempty"`
+	Tunnel    string `xml:"tunnel,attr,omitempty"`
+	Method    string `xml:"method,attr"`
+	Conf      int    `xml:"conf,attr"`
+}
+
+type xmlScript struct {
+	ID     string `xml:"id,attr"`
+	Output string `xml:"output,attr"`
+}
+
+type xmlRunStats struct {
+	Finished xmlFinished `xml:"finished"`
+	Hosts    xmlHosts    `xml:"hosts"`
+}
+
+type xmlFinished struct {
+	Time    int64   `xml:"time,attr"`
+	TimeStr string  `xml:"timestr,attr"`
+	Elapsed float64 `xml:"elapsed,attr"`
+	Summary string  `xml:"summary,attr"`
+	Exit    string  `xml:"exit,attr"`
+	Error   string  `xml:"errormsg,attr,omitempty"`
+}
+
+type xmlHosts struct {
+	Up    int `xml:"up,attr"`
+	Down  int `xml:"down,attr"`
+	Total int `xml:"total,attr"`
+}
+
+// The format used by nmap for the human-readable time strings
+const xmlTimeFormat = "Mon Jan _2 15:04:05 2006"
+
+// WriteXML writes the report as an XML document compatible with nmap's XML
+// output format.
+func (s *Scan) WriteXML(w io.Writer) error {
+	run := xmlNmapRun{
+		Scanner:          s.Scanner,
+		Args:             s.args(),
+		Start:            s.Start.Unix(),
+		StartStr:         s.Start.Format(xmlTimeFormat),
+		XMLOutputVersion: "1.05",
+		ScanInfo: xmlScanInfo{
+			Type:        xmlScanType(s.Protocol),
+			Protocol:    s.Protocol,
+			NumServices: len(s.Ports),
+			Services:    portRanges(s.Ports),
+		},
+		RunStats: xmlRunStats{
+			Finished: xmlFinished{
+				Time:    s.End.Unix(),
+				TimeStr: s.End.Format(xmlTimeFormat),
+				Elapsed: s.Elapsed,
+				Summary: fmt.Sprintf("%s done at %s; %d IP "+
+					"addresses scanned in %.2f seconds",
+					s.Scanner, s.End.Format(xmlTimeFormat),
+					len(s.Hosts), s.Elapsed),
+				Exit: "success",
+			},
+			Hosts: xmlHosts{Up: len(s.Hosts), Total: len(s.Hosts)},
+		},
+	}
+
+	if !s.Complete {
+		run.RunStats.Finished.Exit = "error"
+		run.RunStats.Finished.Error = "The scan was interrupted"
+	}
+
+	for _, h := range s.Hosts {
+		xh := xmlHost{
+			StartTime: s.Start.Unix(),
+			EndTime:   s.End.Unix(),
+			Status:    xmlStatus{"up", "user-set"},
+			Address:   xmlAddress{h.Addr, xmlAddrType(h.Addr)},
+		}
+		if h.Name != "" && h.Name != h.Addr {
+			xh.Hostnames = []xmlHostname{{h.Name, "user"}}
+		}
+		if h.Closed > 0 {
+			xh.Ports.ExtraPorts = append(xh.Ports.ExtraPorts,
+				xmlExtraPorts{"closed", h.Closed})
+		}
+		if h.Filtered > 0 {
+			xh.Ports.ExtraPorts = append(xh.Ports.ExtraPorts,
+				xmlExtraPorts{"filtered", h.Filtered})
+		}
+		for _, p := range h.Ports {
+			xp := xmlPort{
+				Protocol: p.Protocol,
+				PortID:   p.Port,
+				State: xmlState{p.State.String(),
+					xmlReason(p)},
+			}
+			switch {
+			case p.Detected != nil:
+				xp.Service = &xmlService{
+					Name:      p.Detected.Name,
+					Product:   p.Detected.Product,
+					Version:   p.Detected.Version,
+					ExtraInfo: p.Detected.Info,
+					Method:    "probed",
+					Conf:      10,
+				}
+			case p.Service != "":
+				xp.Service = &xmlService{Name: p.Service,
+					Method: "table", Conf: 3}
+			}
+			if p.Banner != "" {
+				xp.Scripts = append(xp.Scripts,
+					xmlScript{"banner", p.Banner})
+			}
+			if p.TLS != nil {
+				if xp.Service != nil {
+					xp.Service.Tunnel = "ssl"
+				}
+				xp.Scripts = append(xp.Scripts,
+					xmlScript{"ssl-cert", xmlCert(p.TLS)})
+			}
+			if p.HTTP != nil {
+				xp.Scripts = append(xp.Scripts,
+					xmlHTTP(p.HTTP)...)
+			}
+			if p.SSH != nil {
+				xp.Scripts = append(xp.Scripts,
+					xmlSSH(p.SSH)...)
+			}
+			xh.Ports.Ports = append(xh.Ports.Ports, xp)
+		}
+		run.Hosts = append(run.Hosts, xh)
+	}
+
+	if _, err := io.WriteString(w, xml.Header+"<!DOCTYPE nmaprun>\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(run); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// args returns the command line flags of the scan in the form of a command
+// line.
+func (s *Scan) args() string {
+	names := make([]string, 0, len(s.Flags))
+	for name := range s.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{s.Scanner}
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("-%s=%s", name, s.Flags[name]))
+	}
+	return strings.Join(args, " ")
+}
+
+// xmlScanType returns the nmap scan type corresponding to the protocol.
+func xmlScanType(protocol string) string {
+	if protocol == "tcp" {
+		return "connect"
+	}
+	return protocol
+}
+
+// xmlAddrType returns the nmap address type of the address.
+func xmlAddrType(addr string) string {
+	if a, err := netip.ParseAddr(addr); err == nil && a.Is6() {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// xmlReason returns the nmap reason for the state of the port.
+func xmlReason(p Port) string {
+	switch {
+	case p.State.IsOpen() && p.Protocol == "tcp":
+		return "syn-ack"
+	case p.State.IsOpen():
+		return "udp-response"
+	case p.State.IsError():
+		return "error"
+	}
+
+	switch p.Reason {
+	case portprobe.ReasonRefused:
+		if p.Protocol == "tcp" {
+			return "conn-refused"
+		}
+		return "port-unreach"
+	case portprobe.ReasonTimeout:
+		return "no-response"
+	case portprobe.ReasonUnreachable:
+		return "host-unreach"
+	}
+	return "unknown"
+}
+
+// xmlCert returns the description of the TLS session and of the service's own
+// certificate, in the spirit of the output of nmap's ssl-cert script.
+func xmlCert(info *tlsprobe.Info) string {
+	lines := []string{fmt.Sprintf("Protocol: %s, %s", info.Version,
+		info.CipherSuite)}
+	if info.ALPN != "" {
+		lines = append(lines, "ALPN: "+info.ALPN)
+	}
+	if len(info.Certificates) > 0 {
+		c := info.Certificates[0]
+		lines = append(lines, "Subject: "+c.Subject)
+		if len(c.SANs) > 0 {
+			lines = append(lines, "Subject Alternative Name: "+
+				strings.Join(c.SANs, ", "))
+		}
+		lines = append(lines, "Issuer: "+c.Issuer,
+			"Not valid before: "+c.NotBefore.Format(time.RFC3339),
+			"Not valid after:  "+c.NotAfter.Format(time.RFC3339))
+	}
+	if info.Expiring() {
+		lines = append(lines, "Warning: a certificate has expired or "+
+			"will expire soon")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// xmlHTTP returns the descriptions of the response of a web server, in the
+// spirit of the output of nmap's http-title and http-server-header scripts.
+func xmlHTTP(info *httpprobe.Info) []xmlScript {
+	title := info.Title
+	switch {
+	case info.Location != "":
+		title = "Did not follow redirect to " + info.Location
+	case title == "":
+		title = "Site doesn't have a title."
+	}
+	scripts := []xmlScript{{"http-title", title}}
+	if info.Server != "" {
+		scripts = append(scripts,
+			xmlScript{"http-server-header", info.Server})
+	}
+	return scripts
+}
+
+// xmlSSH returns the descriptions of an SSH server, in the spirit of the
+// output of nmap's ssh-hostkey and ssh2-enum-algos scripts.
+func xmlSSH(info *sshprobe.Info) []xmlScript {
+	var scripts []xmlScript
+	if info.HostKeySHA256 != "" {
+		scripts = append(scripts, xmlScript{"ssh-hostkey",
+			info.HostKeyType + " " + info.HostKeySHA256})
+	}
+
+	var lines []string
+	lists := []struct {
+		name  string
+		value []string
+	}{
+		{"kex_algorithms", info.KexAlgorithms},
+		{"server_host_key_algorithms", info.HostKeyAlgorithms},
+		{"encryption_algorithms", info.Ciphers},
+		{"mac_algorithms", info.MACs},
+		{"compression_algorithms", info.Compression},
+	}
+	for _, l := range lists {
+		if len(l.value) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: (%d)", l.name,
+			len(l.value)))
+		for _, alg := range l.value {
+			lines = append(lines, "    "+alg)
+		}
+	}
+	if len(lines) > 0 {
+		scripts = append(scripts, xmlScript{"ssh2-enum-algos",
+			strings.Join(lines, "\n")})
+	}
+	return scripts
+}
+
+// portRanges returns the list of ports (which must be in ascending order) in
+// the compact form used by nmap (e.g., "22,80,8000-8100").
+func portRanges(ports []int) string {
+	var ranges []string
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, fmt.Sprint(ports[i]))
+		} else {
+			ranges = append(ranges,
+				fmt.Sprintf("%d-%d", ports[i], ports[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
@@ -0,0 +1,240 @@
+// Unit tests for the XML output of package report.
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/httpprobe"
+	"github.com/webbnh/DigitalOcean/portprobe"
+	"github.com/webbnh/DigitalOcean/sshprobe"
+)
+
+func TestWriteXML(t *testing.T) {
+	s := newTestScan()
+
+	var buf bytes.Buffer
+	if err := s.WriteXML(&buf); err != nil {
+		t.Fatalf("WriteXML() returned \"%v\".\n", err)
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header+"<!DOCTYPE nmaprun>\n") {
+		t.Errorf("Got unexpected prologue:\n%s\n", buf.String())
+	}
+
+	var run xmlNmapRun
+	if err := xml.Unmarshal(buf.Bytes(), &run); err != nil {
+		t.Fatalf("Unable to decode XML:  %v.\n", err)
+	}
+
+	if run.Scanner != "webbscan" || run.Start != s.Start.Unix() ||
+		run.Args != "webbscan -agents=8" {
+		t.Errorf("Got unexpected run attributes %+v.\n", run)
+	}
+	if run.ScanInfo != (xmlScanInfo{"connect", "tcp", 3, "21-22,65000"}) {
+		t.Errorf("Got unexpected scan info %+v.\n", run.ScanInfo)
+	}
+	if len(run.Hosts) != 2 {
+		t.Fatalf("Got %d hosts; expected 2.\n", len(run.Hosts))
+	}
+
+	h := run.Hosts[0]
+	if h.Address != (xmlAddress{"192.0.2.1", "ipv4"}) ||
+		len(h.Hostnames) != 1 || h.Hostnames[0].Name != "web" {
+		t.Errorf("Got unexpected host %+v.\n", h)
+	}
+	if len(h.Ports.ExtraPorts) != 1 ||
+		h.Ports.ExtraPorts[0] != (xmlExtraPorts{"closed", 1}) {
+		t.Errorf("Got unexpected extra ports %+v.\n", h.Ports.ExtraPorts)
+	}
+	if len(h.Ports.Ports) != 2 {
+		t.Fatalf("Got %d ports; expected 2.\n", len(h.Ports.Ports))
+	}
+	if p := h.Ports.Ports[0]; p.PortID != 22 || p.Protocol != "tcp" ||
+		p.State != (xmlState{"open", "syn-ack"}) ||
+		p.Service == nil || *p.Service != (xmlService{"ssh", "OpenSSH",
+		"9.6", "protocol 2.0", "", "probed", 10}) ||
+		len(p.Scripts) != 1 ||
+		p.Scripts[0] != (xmlScript{"banner", "SSH-2.0-OpenSSH_9.6"}) {
+		t.Errorf("Got unexpected port %+v.\n", p)
+	}
+	h = run.Hosts[1]
+	if len(h.Hostnames) != 0 {
+		t.Errorf("Got unexpected host names %+v.\n", h.Hostnames)
+	}
+	if len(h.Ports.ExtraPorts) != 2 ||
+		h.Ports.ExtraPorts[1] != (xmlExtraPorts{"filtered", 1}) {
+		t.Errorf("Got unexpected extra ports %+v.\n", h.Ports.ExtraPorts)
+	}
+	if len(h.Ports.Ports) != 1 || h.Ports.Ports[0].State !=
+		(xmlState{"open|filtered", "no-response"}) {
+		t.Errorf("Got unexpected ports %+v.\n", h.Ports.Ports)
+	}
+	if run.RunStats.Hosts != (xmlHosts{2, 0, 2}) {
+		t.Errorf("Got unexpected host counts %+v.\n",
+			run.RunStats.Hosts)
+	}
+	if f := run.RunStats.Finished; f.Exit != "success" || f.Error != "" {
+		t.Errorf("Got unexpected exit status %+v.\n", f)
+	}
+}
+
+func TestWriteXMLTLS(t *testing.T) {
+	s := New("webbscan", "tcp", []int{443}, time.Now())
+	p := NewPort("tcp", 443, 1)
+	p.TLS = newTestTLS()
+	s.AddPort(s.AddHost("", "192.0.2.1"), p)
+
+	var buf bytes.Buffer
+	if err := s.WriteXML(&buf); err != nil {
+		t.Fatalf("WriteXML() returned \"%v\".\n", err)
+	}
+	var run xmlNmapRun
+	if err := xml.Unmarshal(buf.Bytes(), &run); err != nil {
+		t.Fatalf("Unable to decode XML:  %v.\n", err)
+	}
+
+	expected := "Protocol: TLS 1.3, TLS_AES_128_GCM_SHA256\n" +
+		"ALPN: h2\n" +
+		"Subject: CN=example.com\n" +
+		"Subject Alternative Name: example.com, www.example.com\n" +
+		"Issuer: CN=Example CA\n" +
+		"Not valid before: 2025-01-01T00:00:00Z\n" +
+		"Not valid after:  2026-01-01T00:00:00Z\n" +
+		"Warning: a certificate has expired or will expire soon"
+	xp := run.Hosts[0].Ports.Ports[0]
+	if xp.Service == nil || xp.Service.Tunnel != "ssl" ||
+		len(xp.Scripts) != 1 || xp.Scripts[0] !=
+		(xmlScript{"ssl-cert", expected}) {
+		t.Errorf("Got unexpected port %+v.\n", xp)
+	}
+}
+
+func TestXMLHTTP(t *testing.T) {
+	cases := []struct {
+		info     httpprobe.Info
+		expected []xmlScript
+	}{
+		{httpprobe.Info{Title: "Welcome", Server: "nginx"},
+			[]xmlScript{{"http-title", "Welcome"},
+				{"http-server-header", "nginx"}}},
+		{httpprobe.Info{Location: "/login"},
+			[]xmlScript{{"http-title",
+				"Did not follow redirect to /login"}}},
+		{httpprobe.Info{},
+			[]xmlScript{{"http-title", "Site doesn't have a title."}}},
+	}
+
+	for _, v := range cases {
+		if got := xmlHTTP(&v.info); !reflect.DeepEqual(got, v.expected) {
+			t.Errorf("xmlHTTP(%+v) returned %+v; expected %+v.\n",
+				v.info, got, v.expected)
+		}
+	}
+}
+
+func TestXMLSSH(t *testing.T) {
+	expected := []xmlScript{
+		{"ssh-hostkey", "ssh-ed25519 SHA256:FYudkqDz"},
+		{"ssh2-enum-algos", "kex_algorithms: (2)\n" +
+			"    curve25519-sha256\n" +
+			"    ecdh-sha2-nistp256\n" +
+			"server_host_key_algorithms: (1)\n" +
+			"    ssh-ed25519\n" +
+			"encryption_algorithms: (1)\n" +
+			"    aes128-ctr\n" +
+			"mac_algorithms: (1)\n" +
+			"    hmac-sha2-256\n" +
+			"compression_algorithms: (1)\n" +
+			"    none"},
+	}
+	if got := xmlSSH(newTestSSH()); !reflect.DeepEqual(got, expected) {
+		t.Errorf("xmlSSH() returned %+v; expected %+v.\n", got,
+			expected)
+	}
+
+	info := &sshprobe.Info{Version: "SSH-1.5-Test"}
+	if got := xmlSSH(info); len(got) != 0 {
+		t.Errorf("xmlSSH(%+v) returned %+v.\n", info, got)
+	}
+}
+
+func TestWriteXMLIncomplete(t *testing.T) {
+	s := newTestScan()
+	s.Complete = false
+
+	var buf bytes.Buffer
+	if err := s.WriteXML(&buf); err != nil {
+		t.Fatalf("WriteXML() returned \"%v\".\n", err)
+	}
+
+	var run xmlNmapRun
+	if err := xml.Unmarshal(buf.Bytes(), &run); err != nil {
+		t.Fatalf("Unable to decode XML:  %v.\n", err)
+	}
+	if f := run.RunStats.Finished; f.Exit != "error" || f.Error == "" {
+		t.Errorf("Got unexpected exit status %+v.\n", f)
+	}
+}
+
+func TestPortRanges(t *testing.T) {
+	cases := []struct {
+		ports    []int
+		expected string
+	}{
+		{nil, ""},
+		{[]int{22}, "22"},
+		{[]int{22, 80, 443}, "22,80,443"},
+		{[]int{1, 2, 3, 5, 7, 8}, "1-3,5,7-8"},
+	}
+
+	for _, v := range cases {
+		if got := portRanges(v.ports); got != v.expected {
+			t.Errorf("portRanges(%v) returned \"%s\"; expected \"%s\".\n",
+				v.ports, got, v.expected)
+		}
+	}
+}
+
+func TestXMLAddrType(t *testing.T) {
+	if got := xmlAddrType("2001:db8::1"); got != "ipv6" {
+		t.Errorf("Got \"%s\" for an IPv6 address.\n", got)
+	}
+	if got := xmlAddrType("192.0.2.1"); got != "ipv4" {
+		t.Errorf("Got \"%s\" for an IPv4 address.\n", got)
+	}
+}
+
+func TestXMLReason(t *testing.T) {
+	cases := []struct {
+		protocol string
+		state    portprobe.Result
+		reason   portprobe.Reason
+		expected string
+	}{
+		{"tcp", portprobe.Open, portprobe.ReasonResponse, "syn-ack"},
+		{"udp", portprobe.Open, portprobe.ReasonResponse,
+			"udp-response"},
+		{"tcp", portprobe.Closed, portprobe.ReasonRefused,
+			"conn-refused"},
+		{"udp", portprobe.Closed, portprobe.ReasonRefused,
+			"port-unreach"},
+		{"tcp", portprobe.Filtered, portprobe.ReasonTimeout,
+			"no-response"},
+		{"tcp", portprobe.Filtered, portprobe.ReasonUnreachable,
+			"host-unreach"},
+		{"tcp", portprobe.Closed, portprobe.ReasonNone, "unknown"},
+	}
+
+	for _, v := range cases {
+		p := NewPort(v.protocol, 1, v.state)
+		p.Reason = v.reason
+		if got := xmlReason(p); got != v.expected {
+			t.Errorf("xmlReason(%+v) returned \"%s\"; expected \"%s\".\n",
+				p, got, v.expected)
+		}
+	}
+}
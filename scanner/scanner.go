@@ -0,0 +1,309 @@
+// Package scanner provides an embeddable port scanner, which probes a set of
+// ports on a set of target hosts, concurrently and optionally rate-limited,
+// and delivers the result of each probe as it completes.
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/webbnh/DigitalOcean/httpprobe"
+	"github.com/webbnh/DigitalOcean/portprobe"
+	"github.com/webbnh/DigitalOcean/servprobe"
+	"github.com/webbnh/DigitalOcean/sshprobe"
+	"github.com/webbnh/DigitalOcean/targets"
+	"github.com/webbnh/DigitalOcean/tlsprobe"
+	"github.com/webbnh/DigitalOcean/vdiag"
+	"github.com/webbnh/DigitalOcean/workflow"
+)
+
+// Scanner describes a scan:  which ports to probe on which hosts, and how.
+type Scanner struct {
+	// Hosts to be probed
+	Targets []targets.Target
+	// Ports to be probed on each host
+	Ports []int
+	// Protocol to be probed ("tcp" or "udp")
+	Protocol string
+	// Number of concurrent probes
+	Agents int
+	// Maximum number of probes per second (0: unlimited)
+	Rate int
+	// Maximum number of probes which may be started at once, at the start
+	// of the scan or after a lull, when the rate is limited (0: one)
+	Burst int
+	// If not nil, used to adapt the number of concurrent probes (up to the
+	// number of agents) and their rate (up to the maximum) to the outcomes
+	// of the probes; the scan reports the outcomes to it
+	Controller *workflow.Controller
+	// Parameters of the probes (e.g., timeouts)
+	Prober portprobe.Prober
+	// If not nil, used to identify the service on each open port
+	Detector *servprobe.Detector
+	// If not nil, used to attempt a TLS handshake with each open TCP port
+	TLS *tlsprobe.Prober
+	// If not nil, used to fingerprint the web server (if any) on each
+	// open TCP port
+	HTTP *httpprobe.Prober
+	// If not nil, used to fingerprint the SSH server (if any) on each
+	// open TCP port
+	SSH *sshprobe.Prober
+	// If not nil, called to determine whether the probe of a port on a
+	// host should be omitted (e.g., because it was completed previously);
+	// it is called as the scan progresses, concurrently with the delivery
+	// of the results
+	Exclude func(target targets.Target, port int) bool
+	// If not nil, the context under which the probes (and the inspection
+	// of the open ports) are performed, instead of the scan's context, so
+	// that cancelling the scan stops the dispatch of probes but allows
+	// those in progress to finish (unless this context is done)
+	ProbeContext context.Context
+}
+
+// PortResult is the result of the probe of a single port on a single host.
+type PortResult struct {
+	// Host which was probed
+	Target targets.Target
+	// Port which was probed
+	Port int
+	// Protocol which was probed
+	Protocol string
+	// Result of probe (e.g., open, closed)
+	Result portprobe.Result
+	// Reason for the result (e.g., the target refused the connection)
+	Reason portprobe.Reason
+	// Number of attempts which were made to probe the port
+	Attempts int
+	// Data sent by the service, if banners were requested
+	Banner []byte
+	// Service detected on the port, if detection was requested and the
+	// service was identified
+	Service *servprobe.Service
+	// TLS session negotiated with the service, if TLS inspection was
+	// requested and the service speaks TLS
+	TLS *tlsprobe.Info
+	// Response of the web server to a request for its root page, if
+	// HTTP fingerprinting was requested and the service speaks HTTP
+	HTTP *httpprobe.Info
+	// Description of the SSH server, if SSH fingerprinting was requested
+	// and the service speaks SSH
+	SSH *sshprobe.Info
+}
+
+// Instances of the probe, service detection, TLS inspection, and HTTP and SSH
+// fingerprinting functions which can be overridden for unit testing.
+var (
+	probeFunc  = (*portprobe.Prober).ProbeResponseContext
+	detectFunc = (*servprobe.Detector).DetectContext
+	tlsFunc    = (*tlsprobe.Prober).ProbeContext
+	httpFunc   = (*httpprobe.Prober).ProbeContext
+	sshFunc    = (*sshprobe.Prober).ProbeContext
+)
+
+// Number of probes which are queued for the agents, and of results which are
+// queued for delivery, per agent
+const queuedPerAgent = 4
+
+// probeRequest identifies a single probe to be performed by the scan.
+type probeRequest struct {
+	// Host to be probed
+	target targets.Target
+	// Port to be probed
+	port int
+}
+
+// Size returns the number of probes which the scan will perform.
+func (s *Scanner) Size() int {
+	if s.Exclude == nil {
+		return len(s.Targets) * len(s.Ports)
+	}
+
+	size := 0
+	for _, target := range s.Targets {
+		for _, port := range s.Ports {
+			if !s.Exclude(target, port) {
+				size++
+			}
+		}
+	}
+	return size
+}
+
+// validate checks that the description of the scan is usable.
+func (s *Scanner) validate() error {
+	switch s.Protocol {
+	case "tcp":
+	case "udp":
+	default:
+		return fmt.Errorf("\"%s\" protocol is not supported", s.Protocol)
+	}
+	switch {
+	case len(s.Targets) == 0:
+		return errors.New("no target hosts were specified")
+	case len(s.Ports) == 0:
+		return errors.New("no ports were specified")
+	case s.Agents <= 0:
+		return errors.New("the number of agents must be positive")
+	case s.Rate < 0:
+		return errors.New("the probe rate must not be negative")
+	case s.Burst < 0:
+		return errors.New("the burst size must not be negative")
+	}
+	return nil
+}
+
+// Scan starts the scan and returns a channel on which the result of each
+// probe is delivered as it completes; the channel is closed when the scan is
+// finished.  If the context is cancelled, no further probes are started, and
+// the channel is closed once the probes which are in progress have returned:
+// they are abandoned, unless the ProbeContext is set, in which case they are
+// allowed to finish (the results of the probes which finished are still
+// delivered).  The caller must receive from the channel until it is closed.
+func (s *Scanner) Scan(ctx context.Context) (<-chan PortResult, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	// Capture a copy of the parameters of the scan, so that changes to
+	// them don't affect the probes.  The probes are queued as the agents
+	// become available, so the memory required does not depend on the
+	// size of the scan.
+	sc := *s
+	probeCtx := ctx
+	if s.ProbeContext != nil {
+		probeCtx = s.ProbeContext
+	}
+	pool := workflow.NewStreamPool(probeCtx, s.Agents*queuedPerAgent,
+		s.Agents, 0, func(pctx context.Context,
+			req probeRequest) (PortResult, error) {
+			// Once the scan is cancelled, the probes which were
+			// queued are not started.
+			if err := ctx.Err(); err != nil {
+				return PortResult{}, err
+			}
+			return sc.probe(pctx, req)
+		})
+	limiter := workflow.NewLimiter(s.Rate, s.Burst)
+	pool.SetLimiter(limiter)
+	if s.Controller != nil {
+		s.Controller.SetLimiter(limiter)
+		pool.SetController(s.Controller)
+	}
+
+	// Request a scan of each of the ports on each of the hosts, unless
+	// the scan is cancelled.
+	go func() {
+		defer pool.CloseInput()
+		for _, target := range sc.Targets {
+			for _, port := range sc.Ports {
+				if ctx.Err() != nil {
+					return
+				}
+				if sc.Exclude != nil && sc.Exclude(target, port) {
+					continue
+				}
+				vdiag.Out(6, "Queuing %s port %d.\n", target.Addr,
+					port)
+				pool.Submit(probeRequest{target: target,
+					port: port})
+			}
+		}
+	}()
+
+	results := make(chan PortResult)
+	go func() {
+		defer close(results)
+		defer pool.Destroy()
+
+		// Since the probes are executed concurrently, they may
+		// complete out of order; pass each along as it arrives,
+		// omitting any which were skipped due to cancellation.  (If
+		// the scan is cancelled, the probes which were not started
+		// are discarded.)
+		for {
+			r, ok := pool.Next()
+			if !ok {
+				break
+			}
+			vdiag.Out(5, "Got %v.\n", r)
+			if r.Err != nil || !r.Out.Result.IsComplete() {
+				continue
+			}
+			results <- r.Out
+		}
+	}()
+
+	return results, nil
+}
+
+// probe performs the requested probe, along with the service detection, TLS
+// inspection, and HTTP and SSH fingerprinting, if any, which the scan calls
+// for.  If the context is already done, the port is not probed, and the
+// context's error is returned; if it is done during the probe, the probe is
+// abandoned (leaving its result incomplete, unless it had already finished),
+// and the port is not inspected further.
+func (s *Scanner) probe(ctx context.Context,
+	req probeRequest) (PortResult, error) {
+	if err := ctx.Err(); err != nil {
+		return PortResult{}, err
+	}
+
+	addr, port := req.target.Addr, req.port
+	vdiag.Out(7, "Calling probe for %s:%d\n", addr, port)
+	r := probeFunc(&s.Prober, ctx, s.Protocol, addr, port)
+	if s.Controller != nil && r.Result.IsComplete() {
+		s.Controller.Report(healthy(s.Protocol, r))
+	}
+	result := PortResult{
+		Target:   req.target,
+		Port:     port,
+		Protocol: s.Protocol,
+		Result:   r.Result,
+		Reason:   r.Reason,
+		Attempts: r.Attempts,
+		Banner:   r.Banner,
+	}
+	if r.Result.IsOpen() {
+		s.inspect(ctx, &result)
+	}
+	return result, nil
+}
+
+// inspect performs the service detection, TLS inspection, and HTTP and SSH
+// fingerprinting, if any, which the scan calls for on an open port, recording
+// the outcomes in its result.  Each stage is skipped once the context is done.
+func (s *Scanner) inspect(ctx context.Context, result *PortResult) {
+	addr, port := result.Target.Addr, result.Port
+	if s.Detector != nil && ctx.Err() == nil {
+		result.Service = detectFunc(s.Detector, ctx, s.Protocol, addr,
+			port)
+	}
+	if s.Protocol != "tcp" {
+		return
+	}
+	if s.TLS != nil && ctx.Err() == nil {
+		result.TLS = tlsFunc(s.TLS, ctx, result.Target.Name, addr,
+			port)
+	}
+	if s.HTTP != nil && ctx.Err() == nil {
+		result.HTTP = httpFunc(s.HTTP, ctx, result.Target.Name, addr,
+			port)
+	}
+	if s.SSH != nil && ctx.Err() == nil {
+		result.SSH = sshFunc(s.SSH, ctx, addr, port)
+	}
+}
+
+// healthy returns a boolean indicating whether the result of a probe suggests
+// that the probes are not overwhelming the network or the targets:  a probe
+// which could not be performed is unhealthy, as is a TCP probe which timed
+// out, even if it succeeded when retried.  (A UDP probe usually times out
+// when the port is open or filtered, so its timeouts are not telling.)
+func healthy(protocol string, r portprobe.Response) bool {
+	if r.Result.IsError() {
+		return false
+	}
+	return protocol != "tcp" ||
+		r.Reason != portprobe.ReasonTimeout && r.Attempts <= 1
+}
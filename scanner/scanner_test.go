@@ -0,0 +1,540 @@
+// Unit tests for package scanner
+package scanner
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/httpprobe"
+	"github.com/webbnh/DigitalOcean/portprobe"
+	"github.com/webbnh/DigitalOcean/servprobe"
+	"github.com/webbnh/DigitalOcean/sshprobe"
+	"github.com/webbnh/DigitalOcean/targets"
+	"github.com/webbnh/DigitalOcean/tlsprobe"
+	"github.com/webbnh/DigitalOcean/workflow"
+)
+
+func TestProbe(t *testing.T) {
+	cases := []struct {
+		testPort  int
+		expResult portprobe.Result
+	}{
+		{1, portprobe.Closed},
+		{2, portprobe.Open},
+		{3, portprobe.Pending},
+		{8, portprobe.Open},
+		{5, portprobe.Closed},
+	}
+
+	for _, v := range cases {
+		v := v // Capture for the closure.
+		probeFunc = func(p *portprobe.Prober, ctx context.Context,
+			protocol, host string, port int) portprobe.Response {
+			if port != v.testPort || host != "192.0.2.1" {
+				t.Errorf("Got %s port %d; expected 192.0.2.1 "+
+					"port %d (case %v).\n", host, port,
+					v.testPort, v)
+			}
+			return portprobe.Response{Result: v.expResult,
+				Attempts: 1}
+		}
+
+		s := Scanner{Protocol: "tcp"}
+		r, err := s.probe(context.Background(),
+			probeRequest{target: testTargets[0], port: v.testPort})
+		if err != nil {
+			t.Errorf("probe() returned \"%v\" (case %v).\n", err, v)
+		}
+		if r.Result != v.expResult || r.Attempts != 1 ||
+			r.Port != v.testPort || r.Target != testTargets[0] ||
+			r.Protocol != "tcp" {
+			t.Errorf("Got result %+v; expected \"%v\" "+
+				"(case %v).\n", r, v.expResult, v)
+		}
+	}
+	probeFunc = (*portprobe.Prober).ProbeResponseContext
+
+	// Once the context is done, nothing is probed.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := Scanner{Protocol: "tcp"}
+	_, err := s.probe(ctx, probeRequest{target: testTargets[0], port: 1})
+	if err != context.Canceled {
+		t.Errorf("probe() after cancellation returned \"%v\".\n", err)
+	}
+
+	// If the context is done during the probe, the port is not inspected
+	// further.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	probeFunc = func(p *portprobe.Prober, ctx context.Context,
+		protocol, host string, port int) portprobe.Response {
+		cancel()
+		return portprobe.Response{Result: portprobe.Open}
+	}
+	detectFunc = func(d *servprobe.Detector, ctx context.Context,
+		protocol, host string, port int) *servprobe.Service {
+		t.Error("A cancelled probe's port was inspected.")
+		return nil
+	}
+	defer func() {
+		probeFunc = (*portprobe.Prober).ProbeResponseContext
+		detectFunc = (*servprobe.Detector).DetectContext
+	}()
+	s.Detector = &servprobe.Detector{}
+	r, err := s.probe(ctx, probeRequest{target: testTargets[0], port: 1})
+	if err != nil || !r.Result.IsOpen() {
+		t.Errorf("probe() cancelled during the probe returned %+v, "+
+			"\"%v\".\n", r, err)
+	}
+}
+
+var testTargets = []targets.Target{
+	{Name: "web", Addr: "192.0.2.1"},
+	{Name: "192.0.2.2", Addr: "192.0.2.2"},
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		scanner Scanner
+		isErr   bool
+	}{
+		{Scanner{Targets: testTargets, Ports: []int{22}, Protocol: "tcp",
+			Agents: 1}, false},
+		{Scanner{Targets: testTargets, Ports: []int{22}, Protocol: "udp",
+			Agents: 8, Rate: 100, Burst: 10}, false},
+		{Scanner{Targets: testTargets, Ports: []int{22}, Protocol: "sctp",
+			Agents: 1}, true},
+		{Scanner{Ports: []int{22}, Protocol: "tcp", Agents: 1}, true},
+		{Scanner{Targets: testTargets, Protocol: "tcp", Agents: 1}, true},
+		{Scanner{Targets: testTargets, Ports: []int{22}, Protocol: "tcp"},
+			true},
+		{Scanner{Targets: testTargets, Ports: []int{22}, Protocol: "tcp",
+			Agents: 1, Rate: -1}, true},
+		{Scanner{Targets: testTargets, Ports: []int{22}, Protocol: "tcp",
+			Agents: 1, Rate: 100, Burst: -1}, true},
+	}
+
+	for i, v := range cases {
+		err := v.scanner.validate()
+		if (err != nil) != v.isErr {
+			t.Errorf("Case #%d: validate() returned \"%v\".\n",
+				i, err)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	probeFunc = func(p *portprobe.Prober, ctx context.Context,
+		protocol, host string, port int) portprobe.Response {
+		if protocol != "udp" {
+			t.Errorf("Probe got protocol \"%s\"; expected \"udp\".\n",
+				protocol)
+		}
+		if p.UDPTimeout != 5*time.Second {
+			t.Errorf("Probe got UDP timeout %v; expected 5s.\n",
+				p.UDPTimeout)
+		}
+		if host == "192.0.2.1" && port == 53 {
+			return portprobe.Response{Result: portprobe.Open,
+				Banner: []byte("hi")}
+		}
+		return portprobe.Response{Result: portprobe.Closed}
+	}
+	defer func() { probeFunc = (*portprobe.Prober).ProbeResponseContext }()
+
+	s := Scanner{Targets: testTargets, Ports: []int{53, 123, 161},
+		Protocol: "udp", Agents: 4,
+		Prober: portprobe.Prober{UDPTimeout: 5 * time.Second}}
+	if s.Size() != 6 {
+		t.Errorf("Size() returned %d; expected 6.\n", s.Size())
+	}
+
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan() returned \"%v\".\n", err)
+	}
+
+	type key struct {
+		target targets.Target
+		port   int
+	}
+	seen := make(map[key]PortResult)
+	for r := range results {
+		k := key{r.Target, r.Port}
+		if _, ok := seen[k]; ok {
+			t.Errorf("Got duplicate result %v.\n", r)
+		}
+		seen[k] = r
+	}
+	if len(seen) != s.Size() {
+		t.Errorf("Got %d results; expected %d.\n", len(seen), s.Size())
+	}
+	for _, target := range testTargets {
+		for _, port := range s.Ports {
+			expected := PortResult{Target: target, Port: port,
+				Protocol: "udp", Result: portprobe.Closed}
+			if target.Addr == "192.0.2.1" && port == 53 {
+				expected.Result = portprobe.Open
+				expected.Banner = []byte("hi")
+			}
+			got, ok := seen[key{target, port}]
+			if !ok || !reflect.DeepEqual(got, expected) {
+				t.Errorf("Got result %v for %v port %d; "+
+					"expected %v.\n", got, target, port,
+					expected)
+			}
+		}
+	}
+
+	if _, err := (&Scanner{}).Scan(context.Background()); err == nil {
+		t.Error("Scan() of an empty Scanner unexpectedly succeeded.")
+	}
+}
+
+func TestScanCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	probes := 0
+	probeFunc = func(p *portprobe.Prober, ctx context.Context,
+		protocol, host string, port int) portprobe.Response {
+		// Cancel the scan during the first probe; since there is
+		// only one agent, no other probes should be started.
+		probes++
+		cancel()
+		return portprobe.Response{Result: portprobe.Open}
+	}
+	defer func() { probeFunc = (*portprobe.Prober).ProbeResponseContext }()
+
+	s := Scanner{Targets: testTargets, Ports: []int{1, 2, 3, 4, 5},
+		Protocol: "tcp", Agents: 1}
+	results, err := s.Scan(ctx)
+	if err != nil {
+		t.Fatalf("Scan() returned \"%v\".\n", err)
+	}
+
+	count := 0
+	for range results {
+		count++
+	}
+	if probes != 1 || count != 1 {
+		t.Errorf("Got %d probes and %d results; expected 1 of each.\n",
+			probes, count)
+	}
+}
+
+// With a separate ProbeContext, the probe which is in progress when the scan
+// is cancelled is allowed to finish, including the inspection of its port.
+func TestScanDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	probes := 0
+	probeFunc = func(p *portprobe.Prober, pctx context.Context,
+		protocol, host string, port int) portprobe.Response {
+		probes++
+		cancel()
+		if pctx.Err() != nil {
+			t.Error("The probe's context was cancelled with the scan.")
+		}
+		return portprobe.Response{Result: portprobe.Open}
+	}
+	service := &servprobe.Service{Name: "http"}
+	detectFunc = func(d *servprobe.Detector, ctx context.Context,
+		protocol, host string, port int) *servprobe.Service {
+		return service
+	}
+	defer func() {
+		probeFunc = (*portprobe.Prober).ProbeResponseContext
+		detectFunc = (*servprobe.Detector).DetectContext
+	}()
+
+	s := Scanner{Targets: testTargets, Ports: []int{1, 2, 3, 4, 5},
+		Protocol: "tcp", Agents: 1, Detector: &servprobe.Detector{},
+		ProbeContext: context.Background()}
+	results, err := s.Scan(ctx)
+	if err != nil {
+		t.Fatalf("Scan() returned \"%v\".\n", err)
+	}
+
+	var got []PortResult
+	for r := range results {
+		got = append(got, r)
+	}
+	if probes != 1 || len(got) != 1 || got[0].Service != service {
+		t.Errorf("Got %d probes and results %+v; expected 1 probe, "+
+			"with its service.\n", probes, got)
+	}
+}
+
+func TestScanExclude(t *testing.T) {
+	probeFunc = func(p *portprobe.Prober, ctx context.Context,
+		protocol, host string, port int) portprobe.Response {
+		if port%2 == 0 {
+			t.Errorf("Excluded port %d was probed.\n", port)
+		}
+		return portprobe.Response{Result: portprobe.Closed}
+	}
+	defer func() { probeFunc = (*portprobe.Prober).ProbeResponseContext }()
+
+	s := Scanner{Targets: testTargets, Ports: []int{1, 2, 3, 4, 5},
+		Protocol: "tcp", Agents: 2,
+		Exclude: func(target targets.Target, port int) bool {
+			return port%2 == 0
+		}}
+	if s.Size() != 6 {
+		t.Errorf("Size() returned %d; expected 6.\n", s.Size())
+	}
+
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan() returned \"%v\".\n", err)
+	}
+	count := 0
+	for range results {
+		count++
+	}
+	if count != 6 {
+		t.Errorf("Got %d results; expected 6.\n", count)
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	timeout := portprobe.ReasonTimeout
+	cases := []struct {
+		protocol string
+		result   portprobe.Result
+		reason   portprobe.Reason
+		attempts int
+		healthy  bool
+	}{
+		{"tcp", portprobe.Open, portprobe.ReasonResponse, 1, true},
+		{"tcp", portprobe.Closed, portprobe.ReasonRefused, 1, true},
+		{"tcp", portprobe.Filtered, timeout, 1, false},
+		{"tcp", portprobe.Open, portprobe.ReasonResponse, 2, false},
+		{"tcp", portprobe.Failed, portprobe.ReasonOther, 1, false},
+		{"udp", portprobe.OpenFiltered, timeout, 1, true},
+		{"udp", portprobe.OpenFiltered, timeout, 2, true},
+		{"udp", portprobe.Failed, portprobe.ReasonOther, 1, false},
+	}
+	for _, v := range cases {
+		r := portprobe.Response{Result: v.result, Reason: v.reason,
+			Attempts: v.attempts}
+		if healthy(v.protocol, r) != v.healthy {
+			t.Errorf("healthy() of %s result \"%v\" (%v) after %d "+
+				"attempts returned %v.\n", v.protocol, v.result,
+				v.reason, v.attempts, !v.healthy)
+		}
+	}
+}
+
+func TestScanAdaptive(t *testing.T) {
+	probeFunc = func(p *portprobe.Prober, ctx context.Context,
+		protocol, host string, port int) portprobe.Response {
+		if port <= 3 {
+			return portprobe.Response{Result: portprobe.Failed}
+		}
+		return portprobe.Response{Result: portprobe.Open}
+	}
+	defer func() { probeFunc = (*portprobe.Prober).ProbeResponseContext }()
+
+	ports := make([]int, 100)
+	for i := range ports {
+		ports[i] = i + 1
+	}
+	s := Scanner{Targets: testTargets[:1], Ports: ports, Protocol: "tcp",
+		Agents: 8, Rate: 10000, Burst: 100,
+		Controller: workflow.NewController(1, 8)}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan() returned \"%v\".\n", err)
+	}
+	for range results {
+	}
+
+	// The failed probes ended the slow start, but the healthy ones then
+	// raised the limit (and the rate) to the maximum.
+	if limit, rate := s.Controller.Limit(), s.Controller.Rate(); limit != 8 ||
+		rate != 10000 {
+		t.Errorf("Got limit %d and rate %d; expected 8 and 10000.\n",
+			limit, rate)
+	}
+}
+
+func TestScanDetect(t *testing.T) {
+	probeFunc = func(p *portprobe.Prober, ctx context.Context,
+		protocol, host string, port int) portprobe.Response {
+		if port == 22 {
+			return portprobe.Response{Result: portprobe.Open}
+		}
+		return portprobe.Response{Result: portprobe.Closed}
+	}
+	detectFunc = func(d *servprobe.Detector, ctx context.Context,
+		protocol, host string, port int) *servprobe.Service {
+		if port != 22 {
+			t.Errorf("Detection was attempted on closed port %d.\n",
+				port)
+		}
+		if d.Timeout != time.Second {
+			t.Errorf("Detect got timeout %v; expected 1s.\n",
+				d.Timeout)
+		}
+		return &servprobe.Service{Name: "ssh"}
+	}
+	defer func() {
+		probeFunc = (*portprobe.Prober).ProbeResponseContext
+		detectFunc = (*servprobe.Detector).DetectContext
+	}()
+
+	s := Scanner{Targets: testTargets[:1], Ports: []int{21, 22, 23},
+		Protocol: "tcp", Agents: 2,
+		Detector: &servprobe.Detector{Timeout: time.Second}}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan() returned \"%v\".\n", err)
+	}
+	for r := range results {
+		if (r.Port == 22) != (r.Service != nil) ||
+			r.Service != nil && r.Service.Name != "ssh" {
+			t.Errorf("Got result %+v.\n", r)
+		}
+	}
+}
+
+func TestScanFingerprint(t *testing.T) {
+	probeFunc = func(p *portprobe.Prober, ctx context.Context,
+		protocol, host string, port int) portprobe.Response {
+		if port == 443 {
+			return portprobe.Response{Result: portprobe.Open}
+		}
+		return portprobe.Response{Result: portprobe.Closed}
+	}
+	tlsFunc = func(p *tlsprobe.Prober, ctx context.Context, name,
+		addr string, port int) *tlsprobe.Info {
+		if port != 443 {
+			t.Errorf("A TLS handshake was attempted on closed port "+
+				"%d.\n", port)
+		}
+		if name != "web" || addr != "192.0.2.1" {
+			t.Errorf("A TLS handshake was attempted with %s at %s; "+
+				"expected web at 192.0.2.1.\n", name, addr)
+		}
+		return &tlsprobe.Info{Version: "TLS 1.3"}
+	}
+	httpFunc = func(p *httpprobe.Prober, ctx context.Context, name,
+		addr string, port int) *httpprobe.Info {
+		if port != 443 {
+			t.Errorf("An HTTP request was sent to closed port "+
+				"%d.\n", port)
+		}
+		if name != "web" || addr != "192.0.2.1" {
+			t.Errorf("An HTTP request was sent to %s at %s; "+
+				"expected web at 192.0.2.1.\n", name, addr)
+		}
+		return &httpprobe.Info{Status: 200}
+	}
+	sshFunc = func(p *sshprobe.Prober, ctx context.Context, host string,
+		port int) *sshprobe.Info {
+		if port != 443 {
+			t.Errorf("An SSH exchange was attempted with closed "+
+				"port %d.\n", port)
+		}
+		return &sshprobe.Info{Version: "SSH-2.0-Test"}
+	}
+	defer func() {
+		probeFunc = (*portprobe.Prober).ProbeResponseContext
+		tlsFunc = (*tlsprobe.Prober).ProbeContext
+		httpFunc = (*httpprobe.Prober).ProbeContext
+		sshFunc = (*sshprobe.Prober).ProbeContext
+	}()
+
+	s := Scanner{Targets: testTargets[:1], Ports: []int{80, 443},
+		Protocol: "tcp", Agents: 2, TLS: &tlsprobe.Prober{},
+		HTTP: &httpprobe.Prober{}, SSH: &sshprobe.Prober{}}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan() returned \"%v\".\n", err)
+	}
+	for r := range results {
+		if (r.Port == 443) != (r.TLS != nil) ||
+			(r.Port == 443) != (r.HTTP != nil) ||
+			(r.Port == 443) != (r.SSH != nil) {
+			t.Errorf("Got result %+v.\n", r)
+		}
+	}
+}
+
+// silentListener starts a service which accepts connections but never sends
+// anything, returning its port; the service is stopped (and the connections
+// closed) when the test ends.
+func silentListener(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns []net.Conn
+	done := make(chan bool)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				close(done)
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	t.Cleanup(func() {
+		l.Close()
+		<-done
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// Test that each of the probers gives up promptly when its context is done,
+// rather than waiting for its own timeout.
+func TestProbersCancel(t *testing.T) {
+	cases := []struct {
+		name  string
+		probe func(ctx context.Context, port int) bool
+	}{
+		{"servprobe", func(ctx context.Context, port int) bool {
+			d := servprobe.Detector{Timeout: time.Minute}
+			return d.DetectContext(ctx, "tcp", "127.0.0.1",
+				port) != nil
+		}},
+		{"tlsprobe", func(ctx context.Context, port int) bool {
+			p := tlsprobe.Prober{Timeout: time.Minute}
+			return p.ProbeContext(ctx, "", "127.0.0.1", port) != nil
+		}},
+		{"httpprobe", func(ctx context.Context, port int) bool {
+			p := httpprobe.Prober{Timeout: time.Minute}
+			return p.ProbeContext(ctx, "", "127.0.0.1", port) != nil
+		}},
+		{"sshprobe", func(ctx context.Context, port int) bool {
+			p := sshprobe.Prober{Timeout: time.Minute}
+			return p.ProbeContext(ctx, "127.0.0.1", port) != nil
+		}},
+	}
+	for _, v := range cases {
+		t.Run(v.name, func(t *testing.T) {
+			port := silentListener(t)
+			ctx, cancel := context.WithTimeout(context.Background(),
+				20*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			if v.probe(ctx, port) {
+				t.Error("The probe unexpectedly returned a " +
+					"result.")
+			}
+			elapsed := time.Since(start)
+			if elapsed >= time.Second {
+				t.Errorf("The probe took %v; expected less "+
+					"than 1s.\n", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+// Package servprobe provides support for identifying the service (and,
+// where possible, the product and version) listening on an open port, by
+// sending it protocol-specific probes and matching the responses against a
+// database of signatures, in the spirit of nmap's version detection.
+package servprobe
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/vdiag"
+)
+
+// Default time to wait for the response to each probe
+const defaultTimeout = 2 * time.Second
+
+// Time to wait for more of a response once some of it has arrived
+const moreTimeout = 200 * time.Millisecond
+
+// Maximum size of a response
+const maxResponse = 8192
+
+// Service describes the service which was detected on a port.
+type Service struct {
+	// Name of the service (e.g., "ssh")
+	Name string `json:"name"`
+	// Name of the product which provides the service (e.g., "OpenSSH")
+	Product string `json:"product,omitempty"`
+	// Version of the product
+	Version string `json:"version,omitempty"`
+	// Further information (e.g., the protocol version or the platform)
+	Info string `json:"info,omitempty"`
+}
+
+// String returns the description of the service as a string (e.g., "ssh
+// OpenSSH 9.6p1 (protocol 2.0)").
+func (s *Service) String() string {
+	parts := []string{s.Name}
+	for _, part := range []string{s.Product, s.Version} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if s.Info != "" {
+		parts = append(parts, "("+s.Info+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Detector identifies services using a signature database.  The zero value
+// is usable, and applies the built-in signatures and the default timeout.
+type Detector struct {
+	// Signature database (nil: the built-in signatures)
+	Signatures *Signatures
+	// Time to wait for the response to each probe (0: two seconds)
+	Timeout time.Duration
+}
+
+// Detect identifies the service listening on the specified port of the
+// specified host, returning nil if it cannot be identified.  The probes are
+// sent in the order in which they appear in the database, except that those
+// which are associated with the port are sent first; the first match which
+// is not a soft match ends the search.
+func (d *Detector) Detect(protocol, host string, port int) *Service {
+	return d.DetectContext(context.Background(), protocol, host, port)
+}
+
+// DetectContext is like Detect, but if the context is done before the
+// service is identified, the search is abandoned, and nil is returned.
+func (d *Detector) DetectContext(ctx context.Context, protocol, host string,
+	port int) *Service {
+	sigs := d.Signatures
+	if sigs == nil {
+		sigs = Default()
+	}
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+
+	var soft *Service
+	for _, probe := range sigs.order(protocol, port) {
+		// With nothing to send, a UDP probe can't elicit anything.
+		if protocol == "udp" && len(probe.Payload) == 0 {
+			continue
+		}
+
+		response, err := exchange(ctx, protocol, address,
+			probe.Payload, timeout)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			// The port is no longer reachable; give up.
+			vdiag.Out(4, "Detect(%s:%s) probe %s failed:  %v.\n",
+				protocol, address, probe.Name, err)
+			break
+		}
+		vdiag.Out(6, "Detect(%s:%s) probe %s got %q.\n", protocol,
+			address, probe.Name, response)
+		if len(response) == 0 {
+			continue
+		}
+
+		m, s := probe.match(response)
+		if m == nil {
+			continue
+		}
+		if !m.Soft {
+			return s
+		}
+		if soft == nil {
+			soft = s
+		}
+	}
+	return soft
+}
+
+// order returns the probes for the specified protocol, with those which are
+// associated with the specified port first.
+func (sigs *Signatures) order(protocol string, port int) []*Probe {
+	var first, rest []*Probe
+	for _, p := range sigs.Probes {
+		switch {
+		case p.Protocol != protocol:
+		case p.Ports[port]:
+			first = append(first, p)
+		default:
+			rest = append(rest, p)
+		}
+	}
+	return append(first, rest...)
+}
+
+// match returns the first of the probe's patterns which matches the
+// response, along with the service which it describes, or nil if none does.
+func (p *Probe) match(response []byte) (*Match, *Service) {
+	// Translate each byte into the character with the same value, so
+	// that the patterns can match arbitrary bytes.
+	runes := make([]rune, len(response))
+	for i, b := range response {
+		runes[i] = rune(b)
+	}
+	text := string(runes)
+
+	for _, m := range p.Matches {
+		groups := m.re.FindStringSubmatch(text)
+		if groups == nil {
+			continue
+		}
+		return m, &Service{
+			Name:    m.Service,
+			Product: expand(m.product, groups),
+			Version: expand(m.version, groups),
+			Info:    expand(m.info, groups),
+		}
+	}
+	return nil, nil
+}
+
+// expand returns the template with each "$N" (or nmap's "$P(N)") replaced by
+// the Nth subexpression match, with any non-printable characters removed.
+func expand(template string, groups []string) string {
+	var sb strings.Builder
+	for i := 0; i < len(template); i++ {
+		n, length := -1, 0
+		switch rest := template[i:]; {
+		case len(rest) >= 2 && rest[0] == '$' && isDigit(rest[1]):
+			n, length = int(rest[1]-'0'), 2
+		case len(rest) >= 5 && strings.HasPrefix(rest, "$P(") &&
+			isDigit(rest[3]) && rest[4] == ')':
+			n, length = int(rest[3]-'0'), 5
+		}
+		if n < 0 {
+			sb.WriteByte(template[i])
+			continue
+		}
+		if n < len(groups) {
+			for _, r := range groups[n] {
+				if r >= ' ' && r <= '~' {
+					sb.WriteRune(r)
+				}
+			}
+		}
+		i += length - 1
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// isDigit returns a boolean indicating whether the character is a decimal
+// digit.
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// exchange connects to the specified address using the specified protocol,
+// sends the payload (if any), and returns whatever is received in response
+// within the specified time (or until the context is done).  An error is
+// returned only if the connection cannot be made or the payload cannot be
+// sent.
+func exchange(ctx context.Context, protocol, address string, payload []byte,
+	timeout time.Duration) ([]byte, error) {
+	conn, err := (&net.Dialer{Timeout: timeout}).DialContext(ctx, protocol,
+		address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	defer context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})()
+	if len(payload) > 0 {
+		if _, err := conn.Write(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep reading until the service closes the connection, or until it
+	// pauses after sending something (so that a service which sends a
+	// greeting and then waits for the client doesn't cost the whole
+	// timeout).
+	var response []byte
+	buf := make([]byte, maxResponse)
+	for len(response) < maxResponse {
+		n, err := conn.Read(buf[:maxResponse-len(response)])
+		response = append(response, buf[:n]...)
+		if err != nil || protocol == "udp" {
+			break
+		}
+		if more := time.Now().Add(moreTimeout); more.Before(deadline) {
+			conn.SetReadDeadline(more)
+		}
+	}
+	return response, nil
+}
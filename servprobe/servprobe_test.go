@@ -0,0 +1,181 @@
+// Unit tests for package servprobe
+package servprobe
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen starts a TCP server on the loopback interface which handles each
+// connection using the specified function, and returns its port.
+func listen(t *testing.T, handle func(net.Conn)) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestDetect(t *testing.T) {
+	sshPort := listen(t, func(c net.Conn) {
+		c.Write([]byte("SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13\r\n"))
+		time.Sleep(time.Second)
+	})
+	httpPort := listen(t, func(c net.Conn) {
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		if strings.HasPrefix(line, "GET / ") {
+			c.Write([]byte("HTTP/1.0 200 OK\r\n" +
+				"Content-Type: text/html\r\n" +
+				"Server: nginx/1.24.0\r\n\r\n<html></html>"))
+		}
+	})
+	ftpPort := listen(t, func(c net.Conn) {
+		c.Write([]byte("220 Welcome to the FTP service\r\n"))
+	})
+	silentPort := listen(t, func(c net.Conn) {
+		time.Sleep(time.Second)
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cases := []struct {
+		port     int
+		expected string
+	}{
+		{sshPort, "ssh OpenSSH 9.6p1 Ubuntu 3ubuntu13 " +
+			"(Ubuntu Linux; protocol 2.0)"},
+		{httpPort, "http nginx 1.24.0"},
+		{ftpPort, "ftp"},
+		{silentPort, ""},
+		{closedPort, ""},
+	}
+
+	d := Detector{Timeout: 100 * time.Millisecond}
+	for _, v := range cases {
+		got := d.Detect("tcp", "127.0.0.1", v.port)
+		switch {
+		case got == nil && v.expected != "":
+			t.Errorf("Detect(%d) detected nothing; expected "+
+				"\"%s\".\n", v.port, v.expected)
+		case got != nil && got.String() != v.expected:
+			t.Errorf("Detect(%d) returned \"%v\"; expected "+
+				"\"%s\".\n", v.port, got, v.expected)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	sigs := &Signatures{Probes: []*Probe{
+		{Protocol: "tcp", Name: "A"},
+		{Protocol: "udp", Name: "B", Ports: map[int]bool{53: true}},
+		{Protocol: "tcp", Name: "C", Ports: map[int]bool{80: true}},
+		{Protocol: "tcp", Name: "D", Ports: map[int]bool{443: true}},
+	}}
+
+	cases := []struct {
+		protocol string
+		port     int
+		expected string
+	}{
+		{"tcp", 22, "ACD"},
+		{"tcp", 80, "CAD"},
+		{"tcp", 443, "DAC"},
+		{"udp", 53, "B"},
+	}
+
+	for _, v := range cases {
+		got := ""
+		for _, p := range sigs.order(v.protocol, v.port) {
+			got += p.Name
+		}
+		if got != v.expected {
+			t.Errorf("order(%s, %d) returned %s; expected %s.\n",
+				v.protocol, v.port, got, v.expected)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	sigs, err := Read(strings.NewReader(`Probe TCP NULL q||
+match mysql m|^.\x00\x00\x00\x0a([\d.]+)\x00|s p/MySQL/ v/$1/ i/$P(2)/
+softmatch unknown m|^\xff|
+`))
+	if err != nil {
+		t.Fatalf("Read() returned \"%v\".\n", err)
+	}
+	p := sigs.Probes[0]
+
+	m, s := p.match([]byte("J\x00\x00\x00\x0a8.0.36\x00\x08"))
+	if m == nil || m.Soft || *s != (Service{"mysql", "MySQL", "8.0.36", ""}) {
+		t.Errorf("match() returned %+v, %+v.\n", m, s)
+	}
+	m, s = p.match([]byte("\xff\xfe"))
+	if m == nil || !m.Soft || *s != (Service{Name: "unknown"}) {
+		t.Errorf("match() returned %+v, %+v.\n", m, s)
+	}
+	if m, s = p.match([]byte("hello")); m != nil || s != nil {
+		t.Errorf("match() returned %+v, %+v.\n", m, s)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	groups := []string{"all", "one", "t\x00wo\r\n"}
+	cases := []struct {
+		template string
+		expected string
+	}{
+		{"", ""},
+		{"OpenSSH", "OpenSSH"},
+		{"$1", "one"},
+		{"v$1-$2", "vone-two"},
+		{"$P(2)!", "two!"},
+		{"$3$", "$"},
+		{"$P(x)", "$P(x)"},
+	}
+
+	for _, v := range cases {
+		if got := expand(v.template, groups); got != v.expected {
+			t.Errorf("expand(\"%s\") returned \"%s\"; expected "+
+				"\"%s\".\n", v.template, got, v.expected)
+		}
+	}
+}
+
+func TestServiceString(t *testing.T) {
+	cases := []struct {
+		service  Service
+		expected string
+	}{
+		{Service{Name: "http"}, "http"},
+		{Service{"http", "nginx", "", ""}, "http nginx"},
+		{Service{"ssh", "OpenSSH", "9.6", "protocol 2.0"},
+			"ssh OpenSSH 9.6 (protocol 2.0)"},
+	}
+
+	for _, v := range cases {
+		if got := v.service.String(); got != v.expected {
+			t.Errorf("String() returned \"%s\"; expected \"%s\".\n",
+				got, v.expected)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package servprobe
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/webbnh/DigitalOcean/portlist"
+	"github.com/webbnh/DigitalOcean/vdiag"
+)
+
+// Signatures is a database of service probes and of the patterns used to
+// recognize the responses to them.
+type Signatures struct {
+	Probes []*Probe
+}
+
+// Probe is a message to be sent to a service in order to elicit a response,
+// along with the patterns which recognize the responses.
+type Probe struct {
+	// Protocol used to send the probe ("tcp" or "udp")
+	Protocol string
+	// Name of the probe (e.g., "GetRequest")
+	Name string
+	// Message to be sent (empty, to just wait for the service to speak)
+	Payload []byte
+	// Ports on which the probe is most likely to elicit a response
+	Ports map[int]bool
+	// Patterns to be matched against the response, in order
+	Matches []*Match
+}
+
+// Match is a pattern which recognizes a response to a probe, along with the
+// description of the service which sent it.
+type Match struct {
+	// Name of the service (e.g., "ssh")
+	Service string
+	// If true, the match identifies only the service; if a later match
+	// (e.g., one for the response to a different probe) identifies the
+	// product, it is preferred.
+	Soft bool
+
+	re *regexp.Regexp
+	// Templates for the service description; "$1" through "$9" are
+	// replaced by the corresponding subexpressions of the pattern.
+	product, version, info string
+}
+
+//go:embed signatures.txt
+var defaultText string
+
+// The built-in signatures, parsed on first use
+var defaultSignatures = sync.OnceValue(func() *Signatures {
+	sigs, err := Read(strings.NewReader(defaultText))
+	if err != nil {
+		panic(fmt.Sprintf("built-in signatures: %v", err))
+	}
+	return sigs
+})
+
+// Default returns the built-in signature database.
+func Default() *Signatures {
+	return defaultSignatures()
+}
+
+// Read parses a signature database in (a subset of) the format of nmap's
+// nmap-service-probes file (see https://nmap.org/book/vscan-fileformat.html).
+// The following directives are supported:
+//
+//	Probe <protocol> <name> q|<payload>|
+//	ports <port list>
+//	match <service> m|<pattern>|[flags] [p/<product>/] [v/<version>/]
+//	    [i/<info>/]
+//	softmatch <service> m|<pattern>|[flags]
+//
+// Any delimiter may be used in place of "|" or "/".  The payload may contain
+// the escape sequences "\\", "\0", "\a", "\b", "\f", "\n", "\r", "\t", "\v",
+// and "\xHH".  Patterns are Go regular expressions which are matched against
+// the response as a sequence of bytes (so that, e.g., "\xff" matches the byte
+// 0xff); the "i" and "s" flags are supported.  Other nmap directives and
+// version fields are ignored, as are patterns which are not valid Go regular
+// expressions (e.g., those which use Perl features such as backreferences).
+// Lines which are empty or which start with "#" are ignored.
+func Read(r io.Reader) (*Signatures, error) {
+	sigs := &Signatures{}
+	var probe *Probe
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		directive, rest, _ := strings.Cut(text, " ")
+		rest = strings.TrimSpace(rest)
+		var err error
+		switch directive {
+		case "Probe":
+			probe, err = parseProbe(rest)
+			if err == nil {
+				sigs.Probes = append(sigs.Probes, probe)
+			}
+		case "ports", "match", "softmatch":
+			if probe == nil {
+				err = fmt.Errorf("\"%s\" precedes the first Probe",
+					directive)
+				break
+			}
+			if directive == "ports" {
+				err = probe.parsePorts(rest)
+				break
+			}
+			var m *Match
+			m, err = parseMatch(rest, directive == "softmatch")
+			switch {
+			case err != nil:
+			case m == nil:
+				vdiag.Out(1, "Signatures line %d:  ignoring "+
+					"unsupported pattern.\n", line)
+			default:
+				probe.Matches = append(probe.Matches, m)
+			}
+		case "Exclude", "rarity", "sslports", "totalwaitms",
+			"tcpwrappedms", "fallback":
+			// These nmap directives are not supported.
+		default:
+			err = fmt.Errorf("unrecognized directive \"%s\"",
+				directive)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sigs, nil
+}
+
+// ReadFile reads the specified file and parses its contents as a signature
+// database, as Read does.
+func ReadFile(name string) (*Signatures, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sigs, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return sigs, nil
+}
+
+// parseProbe parses the arguments of a Probe directive.
+func parseProbe(args string) (*Probe, error) {
+	fields := strings.SplitN(args, " ", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid Probe \"%s\"", args)
+	}
+
+	protocol := strings.ToLower(fields[0])
+	if protocol != "tcp" && protocol != "udp" {
+		return nil, fmt.Errorf("invalid Probe protocol \"%s\"",
+			fields[0])
+	}
+
+	spec := strings.TrimSpace(fields[2])
+	if len(spec) < 2 || spec[0] != 'q' {
+		return nil, fmt.Errorf("invalid Probe string \"%s\"", spec)
+	}
+	quoted, _, err := delimited(spec[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Probe string \"%s\": %v",
+			spec, err)
+	}
+	payload, err := unescape(quoted)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Probe string \"%s\": %v",
+			spec, err)
+	}
+
+	return &Probe{
+		Protocol: protocol,
+		Name:     fields[1],
+		Payload:  payload,
+		Ports:    make(map[int]bool),
+	}, nil
+}
+
+// parsePorts parses the arguments of a ports directive.
+func (p *Probe) parsePorts(args string) error {
+	ports, err := portlist.Parse(strings.ReplaceAll(args, " ", ""),
+		p.Protocol)
+	if err != nil {
+		return err
+	}
+	for _, port := range ports {
+		p.Ports[port] = true
+	}
+	return nil
+}
+
+// parseMatch parses the arguments of a match or softmatch directive.  If the
+// pattern is well-formed but cannot be compiled as a Go regular expression,
+// it returns nil and no error.
+func parseMatch(args string, soft bool) (*Match, error) {
+	service, spec, _ := strings.Cut(args, " ")
+	spec = strings.TrimSpace(spec)
+	if service == "" || len(spec) < 2 || spec[0] != 'm' {
+		return nil, fmt.Errorf("invalid match \"%s\"", args)
+	}
+
+	pattern, rest, err := delimited(spec[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid match pattern \"%s\": %v",
+			spec, err)
+	}
+	flags, rest := leadingLetters(rest)
+	for _, f := range flags {
+		if f != 'i' && f != 's' {
+			return nil, fmt.Errorf("invalid match flag '%c'", f)
+		}
+	}
+
+	m := &Match{Service: service, Soft: soft}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		var name, value string
+		if strings.HasPrefix(rest, "cpe:") {
+			name, rest = "cpe", rest[len("cpe:"):]
+		} else {
+			name, rest = rest[:1], rest[1:]
+		}
+		value, rest, err = delimited(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version field \"%s\": %v",
+				name, err)
+		}
+		_, rest = leadingLetters(rest)
+		switch name {
+		case "p":
+			m.product = value
+		case "v":
+			m.version = value
+		case "i":
+			m.info = value
+		}
+		rest = strings.TrimSpace(rest)
+	}
+
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	m.re, err = regexp.Compile(pattern)
+	if err != nil {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// delimited returns the text enclosed by the delimiter which is the first
+// character of s, and the remainder of s following the closing delimiter.
+func delimited(s string) (string, string, error) {
+	if s == "" {
+		return "", "", fmt.Errorf("missing delimiter")
+	}
+	text, rest, ok := strings.Cut(s[1:], s[:1])
+	if !ok {
+		return "", "", fmt.Errorf("missing closing delimiter '%s'",
+			s[:1])
+	}
+	return text, rest, nil
+}
+
+// leadingLetters splits s into its leading letters and the remainder.
+func leadingLetters(s string) (string, string) {
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsLetter(r) })
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+// unescape translates the escape sequences in a probe payload.
+func unescape(s string) ([]byte, error) {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return nil, fmt.Errorf("trailing backslash")
+		}
+		switch s[i] {
+		case '0':
+			b = append(b, 0)
+		case 'a':
+			b = append(b, '\a')
+		case 'b':
+			b = append(b, '\b')
+		case 'f':
+			b = append(b, '\f')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case 'v':
+			b = append(b, '\v')
+		case 'x':
+			if i+2 >= len(s) {
+				return nil, fmt.Errorf("incomplete \"\\x\" escape")
+			}
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid \"\\x%s\" escape",
+					s[i+1:i+3])
+			}
+			b = append(b, byte(v))
+			i += 2
+		default:
+			b = append(b, s[i])
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,129 @@
+// Unit tests for the signature database of package servprobe.
+package servprobe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSignatures = `# Test signatures
+Probe TCP NULL q||
+match ssh m|^SSH-([\d.]+)-OpenSSH_([\w.]+)\r?\n| p/OpenSSH/ v/$2/ i/protocol $1/ cpe:/a:openbsd:openssh:$2/a
+match ssh m|^SSH-(\d)\1| p/Perl-only/
+softmatch ftp m=^220 .*ftp=is
+
+Probe UDP Echo q|\x00\x01\r\n\\|
+ports 7, 9-10
+rarity 1
+match echo m|^\x00\x01| p/$P(9)/
+`
+
+func TestRead(t *testing.T) {
+	sigs, err := Read(strings.NewReader(testSignatures))
+	if err != nil {
+		t.Fatalf("Read() returned \"%v\".\n", err)
+	}
+	if len(sigs.Probes) != 2 {
+		t.Fatalf("Got %d probes; expected 2.\n", len(sigs.Probes))
+	}
+
+	p := sigs.Probes[0]
+	if p.Protocol != "tcp" || p.Name != "NULL" || len(p.Payload) != 0 ||
+		len(p.Ports) != 0 {
+		t.Errorf("Got unexpected probe %+v.\n", p)
+	}
+	// The Perl-only pattern is skipped.
+	if len(p.Matches) != 2 {
+		t.Fatalf("Got %d matches; expected 2.\n", len(p.Matches))
+	}
+	if m := p.Matches[0]; m.Service != "ssh" || m.Soft ||
+		m.product != "OpenSSH" || m.version != "$2" ||
+		m.info != "protocol $1" {
+		t.Errorf("Got unexpected match %+v.\n", m)
+	}
+	if m := p.Matches[1]; m.Service != "ftp" || !m.Soft ||
+		!m.re.MatchString("220 Welcome\r\nFTP") {
+		t.Errorf("Got unexpected match %+v.\n", m)
+	}
+
+	p = sigs.Probes[1]
+	if p.Protocol != "udp" || p.Name != "Echo" ||
+		string(p.Payload) != "\x00\x01\r\n\\" ||
+		!reflect.DeepEqual(p.Ports, map[int]bool{7: true, 9: true,
+			10: true}) || len(p.Matches) != 1 {
+		t.Errorf("Got unexpected probe %+v.\n", p)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	cases := []string{
+		"match ssh m|^SSH|",
+		"Probe TCP NULL",
+		"Probe SCTP NULL q||",
+		"Probe TCP NULL x||",
+		"Probe TCP NULL q|abc",
+		"Probe TCP NULL q|\\x4|",
+		"Probe TCP NULL q|\\xzz|",
+		"Probe TCP NULL q||\nports 80-x",
+		"Probe TCP NULL q||\nmatch ssh",
+		"Probe TCP NULL q||\nmatch ssh m|^SSH",
+		"Probe TCP NULL q||\nmatch ssh m|^SSH|x",
+		"Probe TCP NULL q||\nmatch ssh m|^SSH| p/OpenSSH",
+		"Probe TCP NULL q||\nfrobnicate",
+	}
+
+	for _, v := range cases {
+		if _, err := Read(strings.NewReader(v)); err == nil {
+			t.Errorf("Read(%q) unexpectedly succeeded.\n", v)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	sigs := Default()
+
+	// Every pattern in the built-in database should be usable.
+	expected := 0
+	for _, line := range strings.Split(defaultText, "\n") {
+		if strings.HasPrefix(line, "match ") ||
+			strings.HasPrefix(line, "softmatch ") {
+			expected++
+		}
+	}
+	got := 0
+	for _, p := range sigs.Probes {
+		got += len(p.Matches)
+	}
+	if got != expected {
+		t.Errorf("Got %d patterns; expected %d.\n", got, expected)
+	}
+
+	if Default() != sigs {
+		t.Error("Default() parsed the built-in signatures again.")
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{"", ""},
+		{"GET / HTTP/1.0\\r\\n\\r\\n", "GET / HTTP/1.0\r\n\r\n"},
+		{"\\0\\a\\b\\f\\t\\v\\\\\\|", "\x00\a\b\f\t\v\\|"},
+		{"\\x00\\xFf", "\x00\xff"},
+	}
+
+	for _, v := range cases {
+		got, err := unescape(v.text)
+		if err != nil || string(got) != v.expected {
+			t.Errorf("unescape(%q) returned %q, \"%v\"; expected "+
+				"%q.\n", v.text, got, err, v.expected)
+		}
+	}
+	if _, err := unescape("abc\\"); err == nil {
+		t.Error("unescape() of a trailing backslash unexpectedly " +
+			"succeeded.")
+	}
+}
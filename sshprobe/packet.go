@@ -0,0 +1,169 @@
+package sshprobe
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SSH message numbers (RFC 4253 and RFC 5656)
+const (
+	msgDisconnect   = 1
+	msgIgnore       = 2
+	msgUnimplement  = 3
+	msgDebug        = 4
+	msgKexInit      = 20
+	msgKexECDHInit  = 30
+	msgKexECDHReply = 31
+)
+
+// Maximum size of a packet which will be accepted
+const maxPacket = 256 * 1024
+
+// Number of name-lists in a KEXINIT message
+const kexInitLists = 10
+
+// The name-lists of a KEXINIT message, by position
+const (
+	listKex = iota
+	listHostKey
+	listCipherCS
+	listCipherSC
+	listMACCS
+	listMACSC
+	listCompCS
+	listCompSC
+	listLangCS
+	listLangSC
+)
+
+// readPacket reads an unencrypted binary packet and returns its payload.
+func readPacket(r *bufio.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	padding := uint32(header[4])
+	if length < 1+padding || length > maxPacket {
+		return nil, fmt.Errorf("invalid packet length %d", length)
+	}
+	packet := make([]byte, length-1)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return nil, err
+	}
+	payload := packet[:len(packet)-int(padding)]
+	if len(payload) == 0 {
+		return nil, errors.New("empty packet")
+	}
+	return payload, nil
+}
+
+// writePacket writes the payload as an unencrypted binary packet, with the
+// minimum padding which the protocol allows.
+func writePacket(w io.Writer, payload []byte) error {
+	padding := 8 - (5+len(payload))%8
+	if padding < 4 {
+		padding += 8
+	}
+	packet := make([]byte, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+	packet[4] = byte(padding)
+	copy(packet[5:], payload)
+	_, err := w.Write(packet)
+	return err
+}
+
+// readMessage reads packets until it finds one which is not a message which
+// may be sent at any time and ignored (e.g., SSH_MSG_IGNORE), and returns its
+// payload.  A disconnect message is returned as an error.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	for {
+		payload, err := readPacket(r)
+		if err != nil {
+			return nil, err
+		}
+		switch payload[0] {
+		case msgIgnore, msgUnimplement, msgDebug:
+			continue
+		case msgDisconnect:
+			return nil, fmt.Errorf("disconnected:  %s",
+				disconnectReason(payload))
+		}
+		return payload, nil
+	}
+}
+
+// disconnectReason returns the description in a disconnect message.
+func disconnectReason(payload []byte) string {
+	if len(payload) < 5 {
+		return "no reason given"
+	}
+	desc, _, ok := parseString(payload[5:])
+	if !ok {
+		return fmt.Sprintf("reason %d",
+			binary.BigEndian.Uint32(payload[1:5]))
+	}
+	return string(desc)
+}
+
+// appendString appends the data to the buffer as an SSH string.
+func appendString(buf, data []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+	return append(buf, data...)
+}
+
+// appendNameList appends the names to the buffer as an SSH name-list.
+func appendNameList(buf []byte, names []string) []byte {
+	return appendString(buf, []byte(strings.Join(names, ",")))
+}
+
+// parseString returns the SSH string at the start of the data and whatever
+// follows it.
+func parseString(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 4 {
+		return nil, nil, false
+	}
+	length := binary.BigEndian.Uint32(data)
+	if uint64(length) > uint64(len(data)-4) {
+		return nil, nil, false
+	}
+	return data[4 : 4+length], data[4+length:], true
+}
+
+// parseKexInit returns the name-lists in the payload of a KEXINIT message.
+func parseKexInit(payload []byte) ([kexInitLists][]string, error) {
+	var lists [kexInitLists][]string
+	if len(payload) < 17 || payload[0] != msgKexInit {
+		return lists, errors.New("invalid KEXINIT message")
+	}
+	// Skip the message number and the cookie.
+	rest := payload[17:]
+	for i := range lists {
+		var list []byte
+		var ok bool
+		if list, rest, ok = parseString(rest); !ok {
+			return lists, errors.New("truncated KEXINIT message")
+		}
+		if len(list) > 0 {
+			lists[i] = strings.Split(string(list), ",")
+		}
+	}
+	return lists, nil
+}
+
+// kexInit returns the payload of a KEXINIT message containing the specified
+// name-lists.
+func kexInit(lists [kexInitLists][]string) []byte {
+	// The cookie is supposed to be random, but since the exchange will
+	// never be completed, it doesn't matter.
+	payload := append([]byte{msgKexInit}, make([]byte, 16)...)
+	for _, list := range lists {
+		payload = appendNameList(payload, list)
+	}
+	// No guessed packet follows; the reserved field is zero.
+	return append(payload, 0, 0, 0, 0, 0)
+}
@@ -0,0 +1,83 @@
+// Unit tests for the packet handling of package sshprobe.
+package sshprobe
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPacket(t *testing.T) {
+	for _, size := range []int{1, 2, 3, 4, 11, 12, 100} {
+		payload := bytes.Repeat([]byte{msgKexInit}, size)
+		var buf bytes.Buffer
+		if err := writePacket(&buf, payload); err != nil {
+			t.Fatalf("writePacket() returned \"%v\".\n", err)
+		}
+		if padding := int(buf.Bytes()[4]); buf.Len()%8 != 0 ||
+			padding < 4 || padding > 11 {
+			t.Errorf("writePacket() of %d bytes wrote %d bytes with "+
+				"%d bytes of padding.\n", size, buf.Len(), padding)
+		}
+		got, err := readPacket(bufio.NewReader(&buf))
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Errorf("readPacket() returned %v, \"%v\"; expected "+
+				"%v.\n", got, err, payload)
+		}
+	}
+
+	bad := [][]byte{
+		{},
+		{0, 0, 0, 5, 4},
+		{0, 0, 0, 2, 4, 0},
+		{0, 0, 0, 5, 4, 0, 0, 0, 0},
+		{0x7f, 0, 0, 0, 4},
+	}
+	for _, v := range bad {
+		if _, err := readPacket(bufio.NewReader(bytes.NewReader(v))); err == nil {
+			t.Errorf("readPacket(%v) unexpectedly succeeded.\n", v)
+		}
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	var buf bytes.Buffer
+	writePacket(&buf, []byte{msgIgnore, 1, 2, 3})
+	writePacket(&buf, []byte{msgDebug})
+	writePacket(&buf, []byte{msgKexInit})
+	disconnect := append([]byte{msgDisconnect, 0, 0, 0, 11},
+		appendString(nil, []byte("Too many"))...)
+	writePacket(&buf, disconnect)
+
+	r := bufio.NewReader(&buf)
+	if got, err := readMessage(r); err != nil ||
+		!bytes.Equal(got, []byte{msgKexInit}) {
+		t.Errorf("readMessage() returned %v, \"%v\".\n", got, err)
+	}
+	if _, err := readMessage(r); err == nil ||
+		err.Error() != "disconnected:  Too many" {
+		t.Errorf("readMessage() returned \"%v\".\n", err)
+	}
+}
+
+func TestKexInit(t *testing.T) {
+	var lists [kexInitLists][]string
+	lists[listKex] = []string{"curve25519-sha256", "ecdh-sha2-nistp256"}
+	lists[listCompSC] = []string{"none"}
+
+	got, err := parseKexInit(kexInit(lists))
+	if err != nil || !reflect.DeepEqual(got, lists) {
+		t.Errorf("parseKexInit() returned %v, \"%v\"; expected %v.\n",
+			got, err, lists)
+	}
+
+	if _, err := parseKexInit(kexInit(lists)[:30]); err == nil {
+		t.Error("parseKexInit() of a truncated message unexpectedly " +
+			"succeeded.")
+	}
+	if _, err := parseKexInit([]byte{msgKexECDHInit}); err == nil {
+		t.Error("parseKexInit() of another message unexpectedly " +
+			"succeeded.")
+	}
+}
@@ -0,0 +1,260 @@
+// Package sshprobe provides support for fingerprinting the SSH server on an
+// open TCP port:  it performs the version exchange and enough of the key
+// exchange to learn the server's protocol version string, the algorithms
+// which it supports, and its host key, without authenticating (or even
+// completing the key exchange).
+package sshprobe
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/vdiag"
+)
+
+// Default time to allow for the whole exchange
+const defaultTimeout = 5 * time.Second
+
+// Maximum number of lines which the server may send before its version
+// string, and the maximum length of each (RFC 4253, section 4.2)
+const (
+	maxPreamble = 32
+	maxLine     = 255
+)
+
+// Identification string which is sent to the server
+const clientVersion = "SSH-2.0-webbscan"
+
+// The curve used by each of the key exchange methods which are offered
+var kexCurves = map[string]ecdh.Curve{
+	"curve25519-sha256":            ecdh.X25519(),
+	"curve25519-sha256@libssh.org": ecdh.X25519(),
+	"ecdh-sha2-nistp256":           ecdh.P256(),
+	"ecdh-sha2-nistp384":           ecdh.P384(),
+	"ecdh-sha2-nistp521":           ecdh.P521(),
+}
+
+// The key exchange methods which are offered, in order of preference
+var kexAlgorithms = []string{
+	"curve25519-sha256",
+	"curve25519-sha256@libssh.org",
+	"ecdh-sha2-nistp256",
+	"ecdh-sha2-nistp384",
+	"ecdh-sha2-nistp521",
+}
+
+// The host key algorithms which are preferred, in order; any others which
+// the server supports are offered after these
+var hostKeyAlgorithms = []string{
+	"ssh-ed25519",
+	"ecdsa-sha2-nistp256",
+	"ecdsa-sha2-nistp384",
+	"ecdsa-sha2-nistp521",
+	"rsa-sha2-512",
+	"rsa-sha2-256",
+	"ssh-rsa",
+}
+
+// Info describes an SSH server.
+type Info struct {
+	// Identification string sent by the server (e.g.,
+	// "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13")
+	Version string `json:"version"`
+	// Algorithms supported by the server, in its order of preference
+	// (the ciphers, MACs, and compression methods are those for data
+	// sent by the server)
+	KexAlgorithms     []string `json:"kex_algorithms,omitempty"`
+	HostKeyAlgorithms []string `json:"host_key_algorithms,omitempty"`
+	Ciphers           []string `json:"ciphers,omitempty"`
+	MACs              []string `json:"macs,omitempty"`
+	Compression       []string `json:"compression,omitempty"`
+	// Type of the host key which the server presented (e.g.,
+	// "ssh-ed25519"), if the key exchange got that far
+	HostKeyType string `json:"host_key_type,omitempty"`
+	// Fingerprint of the host key, in the form used by OpenSSH (e.g.,
+	// "SHA256:" followed by the unpadded base64 hash)
+	HostKeySHA256 string `json:"host_key_sha256,omitempty"`
+}
+
+// Prober fingerprints SSH servers.  The zero value is usable, and applies the
+// default timeout.
+type Prober struct {
+	// Time to allow for the whole exchange (0: five seconds)
+	Timeout time.Duration
+}
+
+// Probe fingerprints the SSH server on the specified port of the specified
+// host, returning nil if the service does not identify itself as an SSH
+// server.  If the server's version string is received but the key exchange
+// fails (e.g., because there is no algorithm in common), the description is
+// returned with whatever was learned.
+func (p *Prober) Probe(host string, port int) *Info {
+	return p.ProbeContext(context.Background(), host, port)
+}
+
+// ProbeContext is like Probe, but if the context is done first, the exchange
+// is abandoned (and whatever was learned is returned, as when it fails).
+func (p *Prober) ProbeContext(ctx context.Context, host string,
+	port int) *Info {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := (&net.Dialer{Timeout: timeout}).DialContext(ctx, "tcp",
+		address)
+	if err != nil {
+		vdiag.Out(4, "Probe(%s) failed to connect:  %v.\n", address, err)
+		return nil
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil
+	}
+	defer context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})()
+
+	r := bufio.NewReader(conn)
+	version, err := readVersion(r)
+	if err != nil {
+		vdiag.Out(4, "Probe(%s) got no SSH version:  %v.\n", address,
+			err)
+		return nil
+	}
+	info := &Info{Version: version}
+
+	// Only version 2 of the protocol (which servers announcing "1.99"
+	// also support) has a key exchange which can be attempted.
+	if !strings.HasPrefix(version, "SSH-2.0-") &&
+		!strings.HasPrefix(version, "SSH-1.99-") {
+		return info
+	}
+	if err := exchange(conn, r, info); err != nil {
+		vdiag.Out(4, "Probe(%s) key exchange failed:  %v.\n", address,
+			err)
+	}
+	return info
+}
+
+// readVersion reads the server's identification string, skipping any lines
+// which precede it.
+func readVersion(r *bufio.Reader) (string, error) {
+	for i := 0; i < maxPreamble; i++ {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			return "", err
+		}
+		if len(line) > maxLine {
+			return "", errors.New("line too long")
+		}
+		s := strings.TrimRight(string(line), "\r\n")
+		if strings.HasPrefix(s, "SSH-") {
+			return s, nil
+		}
+	}
+	return "", errors.New("too many lines before the version")
+}
+
+// exchange sends the client's version and performs the key exchange up to
+// the point at which the server sends its host key, recording what it learns
+// in the description of the server.
+func exchange(conn net.Conn, r *bufio.Reader, info *Info) error {
+	if _, err := conn.Write([]byte(clientVersion + "\r\n")); err != nil {
+		return err
+	}
+
+	payload, err := readMessage(r)
+	if err != nil {
+		return err
+	}
+	server, err := parseKexInit(payload)
+	if err != nil {
+		return err
+	}
+	info.KexAlgorithms = server[listKex]
+	info.HostKeyAlgorithms = server[listHostKey]
+	info.Ciphers = server[listCipherSC]
+	info.MACs = server[listMACSC]
+	info.Compression = server[listCompSC]
+
+	// The server chooses the first of the client's algorithms which it
+	// supports.  Offering the server's own ciphers, MACs, and compression
+	// methods ensures that the negotiation succeeds.
+	kex := negotiate(kexAlgorithms, server[listKex])
+	if kex == "" {
+		return errors.New("no key exchange method in common")
+	}
+	client := server
+	client[listKex] = kexAlgorithms
+	client[listHostKey] = slices.Clone(hostKeyAlgorithms)
+	for _, alg := range server[listHostKey] {
+		if !slices.Contains(hostKeyAlgorithms, alg) {
+			client[listHostKey] = append(client[listHostKey], alg)
+		}
+	}
+	client[listLangCS], client[listLangSC] = nil, nil
+	if err := writePacket(conn, kexInit(client)); err != nil {
+		return err
+	}
+
+	key, err := kexCurves[kex].GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	ecdhInit := appendString([]byte{msgKexECDHInit},
+		key.PublicKey().Bytes())
+	if err := writePacket(conn, ecdhInit); err != nil {
+		return err
+	}
+
+	reply, err := readMessage(r)
+	if err != nil {
+		return err
+	}
+	if reply[0] != msgKexECDHReply {
+		return fmt.Errorf("unexpected message %d", reply[0])
+	}
+	hostKey, _, ok := parseString(reply[1:])
+	if !ok {
+		return errors.New("invalid key exchange reply")
+	}
+	keyType, _, ok := parseString(hostKey)
+	if !ok {
+		return errors.New("invalid host key")
+	}
+	info.HostKeyType = string(keyType)
+	info.HostKeySHA256 = Fingerprint(hostKey)
+	return nil
+}
+
+// negotiate returns the first of the client's algorithms which the server
+// supports, or an empty string if there is none.
+func negotiate(client, server []string) string {
+	for _, alg := range client {
+		if slices.Contains(server, alg) {
+			return alg
+		}
+	}
+	return ""
+}
+
+// Fingerprint returns the SHA256 fingerprint of the host key (in the SSH wire
+// format) in the form used by OpenSSH (e.g., "SHA256:" followed by the
+// unpadded base64 hash).
+func Fingerprint(hostKey []byte) string {
+	sum := sha256.Sum256(hostKey)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
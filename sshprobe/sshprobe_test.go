@@ -0,0 +1,157 @@
+// Unit tests for package sshprobe
+package sshprobe
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// An Ed25519 host key and its fingerprint, as reported by ssh-keygen
+const (
+	testHostKey = "AAAAC3NzaC1lZDI1NTE5AAAAIBvNLF8ryEI359WHy4GOABegp+7f1B" +
+		"vIOPCfPHNcezLC"
+	testFingerprint = "SHA256:FYudkqDzyf/7ahHxJfGHo6dtpG8WWYsaOUKV6i4wc24"
+)
+
+// serve starts an imitation SSH server on the loopback interface which sends
+// the specified version string and, for version 2, a KEXINIT message offering
+// the specified key exchange methods, followed by a key exchange reply
+// containing the test host key; it returns the server's port.
+func serve(t *testing.T, version string, kex []string) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	hostKey, err := base64.StdEncoding.DecodeString(testHostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lists [kexInitLists][]string
+	lists[listKex] = kex
+	lists[listHostKey] = []string{"rsa-sha2-512", "ssh-ed25519"}
+	for _, i := range []int{listCipherCS, listCipherSC} {
+		lists[i] = []string{"aes128-ctr", "aes256-ctr"}
+	}
+	for _, i := range []int{listMACCS, listMACSC} {
+		lists[i] = []string{"hmac-sha2-256"}
+	}
+	for _, i := range []int{listCompCS, listCompSC} {
+		lists[i] = []string{"none"}
+	}
+
+	handle := func(conn net.Conn) {
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte("Welcome\r\n" + version + "\r\n"))
+		r := bufio.NewReader(conn)
+		if line, err := r.ReadString('\n'); err != nil ||
+			line != clientVersion+"\r\n" {
+			return
+		}
+		writePacket(conn, []byte{msgIgnore})
+		writePacket(conn, kexInit(lists))
+
+		payload, err := readMessage(r)
+		if err != nil || payload[0] != msgKexInit {
+			return
+		}
+		payload, err = readMessage(r)
+		if err != nil || payload[0] != msgKexECDHInit {
+			return
+		}
+		reply := appendString([]byte{msgKexECDHReply}, hostKey)
+		reply = appendString(reply, make([]byte, 32))
+		reply = appendString(reply, []byte("signature"))
+		writePacket(conn, reply)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestProbe(t *testing.T) {
+	algorithms := Info{
+		HostKeyAlgorithms: []string{"rsa-sha2-512", "ssh-ed25519"},
+		Ciphers:           []string{"aes128-ctr", "aes256-ctr"},
+		MACs:              []string{"hmac-sha2-256"},
+		Compression:       []string{"none"},
+	}
+
+	full := algorithms
+	full.Version = "SSH-2.0-TestSSH_1.0"
+	full.KexAlgorithms = []string{"diffie-hellman-group14-sha256",
+		"ecdh-sha2-nistp256", "curve25519-sha256"}
+	full.HostKeyType = "ssh-ed25519"
+	full.HostKeySHA256 = testFingerprint
+
+	nocommon := algorithms
+	nocommon.Version = "SSH-1.99-TestSSH_1.0"
+	nocommon.KexAlgorithms = []string{"diffie-hellman-group14-sha256"}
+
+	cases := []struct {
+		port     int
+		expected *Info
+	}{
+		{serve(t, full.Version, full.KexAlgorithms), &full},
+		{serve(t, nocommon.Version, nocommon.KexAlgorithms), &nocommon},
+		{serve(t, "SSH-1.5-TestSSH_1.0", nil),
+			&Info{Version: "SSH-1.5-TestSSH_1.0"}},
+		{serve(t, "220 Welcome to the FTP service", nil), nil},
+	}
+
+	p := Prober{Timeout: time.Second}
+	for _, v := range cases {
+		if got := p.Probe("127.0.0.1", v.port); !reflect.DeepEqual(got,
+			v.expected) {
+			t.Errorf("Probe() returned %+v; expected %+v.\n", got,
+				v.expected)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	hostKey, err := base64.StdEncoding.DecodeString(testHostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Fingerprint(hostKey); got != testFingerprint {
+		t.Errorf("Fingerprint() returned \"%s\"; expected \"%s\".\n",
+			got, testFingerprint)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		client   string
+		server   string
+		expected string
+	}{
+		{"a,b,c", "c,b", "b"},
+		{"a", "b", ""},
+		{"a", "", ""},
+	}
+
+	for _, v := range cases {
+		got := negotiate(strings.Split(v.client, ","),
+			strings.Split(v.server, ","))
+		if got != v.expected {
+			t.Errorf("negotiate(%s, %s) returned \"%s\"; expected "+
+				"\"%s\".\n", v.client, v.server, got, v.expected)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Package targets provides support for translating target specifications,
+// such as those given on the command line or in a file, into lists of
+// addresses to be scanned.
+//
+// A specification is a list of entries, separated by commas or white space,
+// each of which may be an IP address ("10.0.0.5"), a CIDR block
+// ("10.0.0.0/24"), a range of IPv4 addresses ("10.0.0.5-40" or
+// "10.0.0.5-10.0.0.40"), or a host name, which is translated into all of the
+// addresses that it resolves to.
+package targets
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MaxExpansion is the maximum number of addresses to which a single entry
+// may be expanded.
+const MaxExpansion = 1 << 16
+
+// A Target is a single address to be scanned.
+type Target struct {
+	// The name by which the user specified the target (e.g., a host
+	// name); this is the same as Addr if the user specified an address.
+	Name string
+	// The IP address of the target
+	Addr string
+}
+
+// String returns the target as a string, showing the name, if there is one,
+// and the address.
+func (t Target) String() string {
+	if t.Name == "" || t.Name == t.Addr {
+		return t.Addr
+	}
+	return fmt.Sprintf("%s (%s)", t.Name, t.Addr)
+}
+
+// The host name resolver, which can be overridden for unit testing
+var lookupHost = net.LookupHost
+
+// Parse translates the specification into a list of targets, with duplicate
+// addresses removed.
+func Parse(spec string) ([]Target, error) {
+	fields := strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+	var list []Target
+	for _, entry := range fields {
+		t, err := parseEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t...)
+	}
+	return Merge(list), nil
+}
+
+// Read translates the specifications read from r, one or more per line, into
+// a list of targets, with duplicate addresses removed.  Anything following a
+// "#" on a line is ignored.
+func Read(r io.Reader) ([]Target, error) {
+	var list []Target
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		t, err := Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		list = append(list, t...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return Merge(list), nil
+}
+
+// ReadFile reads the specified file and translates its contents into a list
+// of targets, as Read does.
+func ReadFile(name string) ([]Target, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return list, nil
+}
+
+// Merge concatenates the lists of targets, retaining only the first instance
+// of each address.
+func Merge(lists ...[]Target) []Target {
+	seen := make(map[string]bool)
+	var merged []Target
+	for _, list := range lists {
+		for _, t := range list {
+			if !seen[t.Addr] {
+				seen[t.Addr] = true
+				merged = append(merged, t)
+			}
+		}
+	}
+	return merged
+}
+
+// parseEntry translates a single entry from a specification into a list of
+// targets.
+func parseEntry(entry string) ([]Target, error) {
+	if addr, err := netip.ParseAddr(entry); err == nil {
+		return []Target{{entry, addr.String()}}, nil
+	}
+
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR block \"%s\"", entry)
+		}
+		return expandPrefix(entry, prefix.Masked())
+	}
+
+	// If the part before the dash is an address, then it's a range;
+	// otherwise, it's a host name (which might contain a dash).
+	if lowStr, highStr, ok := strings.Cut(entry, "-"); ok {
+		if low, err := netip.ParseAddr(lowStr); err == nil {
+			return expandRange(entry, low, highStr)
+		}
+	}
+
+	addrs, err := lookupHost(entry)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve \"%s\": %v",
+			entry, err)
+	}
+	var list []Target
+	for _, a := range addrs {
+		list = append(list, Target{entry, a})
+	}
+	return list, nil
+}
+
+// expandPrefix translates a CIDR block into the list of all of the addresses
+// which it contains.
+func expandPrefix(entry string, prefix netip.Prefix) ([]Target, error) {
+	if hostBits := prefix.Addr().BitLen() - prefix.Bits(); hostBits > 16 {
+		return nil, fmt.Errorf("CIDR block \"%s\" has more than %d "+
+			"addresses", entry, MaxExpansion)
+	}
+
+	var list []Target
+	for a := prefix.Addr(); prefix.Contains(a); a = a.Next() {
+		list = append(list, Target{a.String(), a.String()})
+	}
+	return list, nil
+}
+
+// expandRange translates an address range into the list of all of the
+// addresses which it contains.  The end of the range may be either a full
+// address or just the final octet of an IPv4 address.
+func expandRange(entry string, low netip.Addr, highStr string) ([]Target, error) {
+	if !low.Is4() {
+		return nil, fmt.Errorf("address range \"%s\" is not IPv4", entry)
+	}
+
+	high, err := netip.ParseAddr(highStr)
+	if err != nil {
+		octet, err := strconv.Atoi(highStr)
+		if err != nil || octet < 0 || octet > 255 {
+			return nil, fmt.Errorf("invalid address range \"%s\"",
+				entry)
+		}
+		b := low.As4()
+		b[3] = byte(octet)
+		high = netip.AddrFrom4(b)
+	}
+	if !high.Is4() || high.Less(low) {
+		return nil, fmt.Errorf("invalid address range \"%s\"", entry)
+	}
+
+	var list []Target
+	for a := low; a.IsValid() && a.Compare(high) <= 0; a = a.Next() {
+		if len(list) == MaxExpansion {
+			return nil, fmt.Errorf("address range \"%s\" has more "+
+				"than %d addresses", entry, MaxExpansion)
+		}
+		list = append(list, Target{a.String(), a.String()})
+	}
+	return list, nil
+}
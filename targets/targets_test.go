@@ -0,0 +1,112 @@
+// Unit tests for package targets.
+package targets
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mockLookupHost resolves a few fixed names.
+func mockLookupHost(host string) ([]string, error) {
+	switch host {
+	case "web-server":
+		return []string{"192.0.2.10", "2001:db8::10"}, nil
+	case "db":
+		return []string{"192.0.2.20"}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func addrs(list []Target) []string {
+	var a []string
+	for _, t := range list {
+		a = append(a, t.Addr)
+	}
+	return a
+}
+
+func TestParse(t *testing.T) {
+	lookupHost = mockLookupHost
+
+	cases := []struct {
+		spec     string
+		expected []string
+		isErr    bool
+	}{
+		{"10.0.0.1", []string{"10.0.0.1"}, false},
+		{"2001:db8::1", []string{"2001:db8::1"}, false},
+		{"10.0.0.1,10.0.0.2 10.0.0.1", []string{"10.0.0.1", "10.0.0.2"},
+			false},
+		{"10.0.0.0/30", []string{"10.0.0.0", "10.0.0.1", "10.0.0.2",
+			"10.0.0.3"}, false},
+		{"10.0.0.6/30", []string{"10.0.0.4", "10.0.0.5", "10.0.0.6",
+			"10.0.0.7"}, false},
+		{"10.0.0.5-7", []string{"10.0.0.5", "10.0.0.6", "10.0.0.7"},
+			false},
+		{"10.0.0.255-10.0.1.1", []string{"10.0.0.255", "10.0.1.0",
+			"10.0.1.1"}, false},
+		{"255.255.255.254-255", []string{"255.255.255.254",
+			"255.255.255.255"}, false},
+		{"web-server,db", []string{"192.0.2.10", "2001:db8::10",
+			"192.0.2.20"}, false},
+		{"10.0.0.0/8", nil, true},
+		{"10.0.0.0/33", nil, true},
+		{"10.0.0.7-5", nil, true},
+		{"10.0.0.5-256", nil, true},
+		{"2001:db8::1-2", nil, true},
+		{"no-such-host", nil, true},
+	}
+
+	for i, v := range cases {
+		got, err := Parse(v.spec)
+		if (err != nil) != v.isErr {
+			t.Errorf("Case #%d: Parse(\"%s\") returned error \"%v\".\n",
+				i, v.spec, err)
+			continue
+		}
+		if !v.isErr && !reflect.DeepEqual(addrs(got), v.expected) {
+			t.Errorf("Case #%d: Parse(\"%s\") returned %v; expected %v.\n",
+				i, v.spec, addrs(got), v.expected)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	lookupHost = mockLookupHost
+
+	input := "# Lab hosts\n10.0.0.1\n\ndb  # The database\n10.0.0.1-2\n"
+	got, err := Read(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Read() returned error \"%v\".\n", err)
+	}
+	expected := []string{"10.0.0.1", "192.0.2.20", "10.0.0.2"}
+	if !reflect.DeepEqual(addrs(got), expected) {
+		t.Errorf("Read() returned %v; expected %v.\n",
+			addrs(got), expected)
+	}
+
+	_, err = Read(strings.NewReader("10.0.0.1\nbogus-host\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("Read() returned error \"%v\"; expected line 2.\n", err)
+	}
+}
+
+func TestString(t *testing.T) {
+	cases := []struct {
+		target   Target
+		expected string
+	}{
+		{Target{"10.0.0.1", "10.0.0.1"}, "10.0.0.1"},
+		{Target{"", "10.0.0.1"}, "10.0.0.1"},
+		{Target{"db", "192.0.2.20"}, "db (192.0.2.20)"},
+	}
+
+	for _, v := range cases {
+		if got := v.target.String(); got != v.expected {
+			t.Errorf("Got \"%s\"; expected \"%s\".\n",
+				got, v.expected)
+		}
+	}
+}
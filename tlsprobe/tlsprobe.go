@@ -0,0 +1,172 @@
+// Package tlsprobe provides support for determining whether the service on
+// an open TCP port speaks TLS and, if so, for describing the session which it
+// negotiates and the certificates which it presents.
+package tlsprobe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/webbnh/DigitalOcean/vdiag"
+)
+
+// Default time to allow for the connection and the handshake
+const defaultTimeout = 3 * time.Second
+
+// Default period before expiry within which certificates are flagged
+const defaultExpiryWarn = 30 * 24 * time.Hour
+
+// The application protocols offered in the handshake, by default
+var defaultALPN = []string{"h2", "http/1.1"}
+
+// Info describes the TLS session negotiated with a service.
+type Info struct {
+	// TLS version (e.g., "TLS 1.3")
+	Version string `json:"version"`
+	// Cipher suite (e.g., "TLS_AES_128_GCM_SHA256")
+	CipherSuite string `json:"cipher_suite"`
+	// Application protocol selected by the service, if any (e.g., "h2")
+	ALPN string `json:"alpn,omitempty"`
+	// Certificate chain presented by the service, starting with the
+	// service's own certificate
+	Certificates []Certificate `json:"certificates"`
+}
+
+// Certificate describes a certificate presented by a service.
+type Certificate struct {
+	// Distinguished name of the subject
+	Subject string `json:"subject"`
+	// Subject alternative names (DNS names, IP addresses, email
+	// addresses, and URIs)
+	SANs []string `json:"sans,omitempty"`
+	// Distinguished name of the issuer
+	Issuer string `json:"issuer"`
+	// Start and end of the period in which the certificate is valid
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	// True if the certificate has expired or will expire soon
+	Expiring bool `json:"expiring,omitempty"`
+}
+
+// Expiring returns a boolean indicating whether any of the certificates in the
+// chain has expired or will expire soon.
+func (info *Info) Expiring() bool {
+	for _, c := range info.Certificates {
+		if c.Expiring {
+			return true
+		}
+	}
+	return false
+}
+
+// Prober performs TLS handshakes.  The zero value is usable, and applies the
+// defaults described below.
+type Prober struct {
+	// Time to allow for the connection and the handshake (0: three
+	// seconds)
+	Timeout time.Duration
+	// Certificates which expire within this period are flagged (0: 30
+	// days)
+	ExpiryWarn time.Duration
+	// Application protocols to offer (nil: "h2" and "http/1.1")
+	ALPN []string
+}
+
+// Instance of the clock function which can be overridden for unit testing
+var nowFunc = time.Now
+
+// Probe attempts a TLS handshake with the service on the specified port of
+// the specified host, returning a description of the session, or nil if the
+// handshake fails (e.g., because the service does not speak TLS).  The
+// certificates are not verified, since the object is to inspect them.  If
+// the host is specified by name, the name is sent to the service (for virtual
+// hosting).
+func (p *Prober) Probe(host string, port int) *Info {
+	return p.ProbeContext(context.Background(), host, host, port)
+}
+
+// ProbeContext is like Probe, but it connects to the specified address,
+// sending the specified name to the service (unless it is empty or an IP
+// address), so that a service which hosts several names presents the
+// certificate for that one.  If the context is done before the handshake is
+// complete, the handshake is abandoned, and nil is returned.
+func (p *Prober) ProbeContext(ctx context.Context, name, addr string,
+	port int) *Info {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	alpn := p.ALPN
+	if alpn == nil {
+		alpn = defaultALPN
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         alpn,
+	}
+	// Send the host name for virtual hosting, if it is one.
+	if name != "" && net.ParseIP(name) == nil {
+		config.ServerName = name
+	}
+
+	address := net.JoinHostPort(addr, strconv.Itoa(port))
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: timeout},
+		Config:    config,
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		vdiag.Out(4, "Probe(%s) TLS handshake failed:  %v.\n", address,
+			err)
+		return nil
+	}
+	defer conn.Close()
+
+	return p.describe(conn.(*tls.Conn).ConnectionState())
+}
+
+// describe returns the description of the TLS session with the specified
+// state.
+func (p *Prober) describe(state tls.ConnectionState) *Info {
+	warn := p.ExpiryWarn
+	if warn <= 0 {
+		warn = defaultExpiryWarn
+	}
+	deadline := nowFunc().Add(warn)
+
+	info := &Info{
+		Version:      tls.VersionName(state.Version),
+		CipherSuite:  tls.CipherSuiteName(state.CipherSuite),
+		ALPN:         state.NegotiatedProtocol,
+		Certificates: []Certificate{},
+	}
+	for _, c := range state.PeerCertificates {
+		info.Certificates = append(info.Certificates, Certificate{
+			Subject:   c.Subject.String(),
+			SANs:      sans(c),
+			Issuer:    c.Issuer.String(),
+			NotBefore: c.NotBefore.UTC(),
+			NotAfter:  c.NotAfter.UTC(),
+			Expiring:  c.NotAfter.Before(deadline),
+		})
+	}
+	return info
+}
+
+// sans returns the subject alternative names of the certificate.
+func sans(c *x509.Certificate) []string {
+	names := append([]string{}, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		names = append(names, u.String())
+	}
+	return names
+}
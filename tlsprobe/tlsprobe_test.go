@@ -0,0 +1,190 @@
+// Unit tests for package tlsprobe
+package tlsprobe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newCertificate returns a self-signed certificate for "example.com" which
+// expires at the specified time.
+func newCertificate(t *testing.T, notAfter time.Time) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "example.com",
+			Organization: []string{"Example"}},
+		DNSNames:    []string{"example.com", "www.example.com"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:   notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:    notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// listen starts a TLS server on the loopback interface which presents the
+// specified certificate, and returns its port.
+func listen(t *testing.T, cert tls.Certificate) int {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"http/1.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestProbe(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func(f func() time.Time) { nowFunc = f }(nowFunc)
+	nowFunc = func() time.Time { return now }
+
+	notAfter := now.Add(10 * 24 * time.Hour)
+	port := listen(t, newCertificate(t, notAfter))
+
+	cases := []struct {
+		warn     time.Duration
+		expiring bool
+	}{
+		{0, true},
+		{5 * 24 * time.Hour, false},
+		{10*24*time.Hour + time.Second, true},
+	}
+
+	for _, v := range cases {
+		p := Prober{Timeout: time.Second, ExpiryWarn: v.warn}
+		info := p.Probe("127.0.0.1", port)
+		if info == nil {
+			t.Fatalf("Probe() returned nil.\n")
+		}
+		expected := &Info{
+			Version:     "TLS 1.3",
+			CipherSuite: info.CipherSuite,
+			ALPN:        "http/1.1",
+			Certificates: []Certificate{{
+				Subject: "CN=example.com,O=Example",
+				SANs: []string{"example.com", "www.example.com",
+					"127.0.0.1"},
+				Issuer:    "CN=example.com,O=Example",
+				NotBefore: notAfter.Add(-365 * 24 * time.Hour),
+				NotAfter:  notAfter,
+				Expiring:  v.expiring,
+			}},
+		}
+		if !reflect.DeepEqual(info, expected) {
+			t.Errorf("Probe() with warning period %v returned "+
+				"%+v; expected %+v.\n", v.warn, info, expected)
+		}
+		if info.CipherSuite == "" {
+			t.Error("Probe() returned no cipher suite.")
+		}
+		if info.Expiring() != v.expiring {
+			t.Errorf("Expiring() returned %v; expected %v.\n",
+				info.Expiring(), v.expiring)
+		}
+	}
+}
+
+func TestProbeServerName(t *testing.T) {
+	cert := newCertificate(t, time.Now().Add(24*time.Hour))
+	names := make(chan string, 1)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (
+			*tls.Certificate, error) {
+			names <- hello.ServerName
+			return &cert, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{"www.example.com", "www.example.com"},
+		{"127.0.0.1", ""},
+		{"", ""},
+	}
+	p := Prober{Timeout: time.Second}
+	for _, v := range cases {
+		info := p.ProbeContext(context.Background(), v.name, "127.0.0.1",
+			port)
+		if info == nil {
+			t.Fatalf("ProbeContext(%q) returned nil.\n", v.name)
+		}
+		if got := <-names; got != v.expected {
+			t.Errorf("ProbeContext(%q) sent server name %q; "+
+				"expected %q.\n", v.name, got, v.expected)
+		}
+	}
+}
+
+func TestProbeNotTLS(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			conn.Close()
+		}
+	}()
+
+	p := Prober{Timeout: time.Second}
+	if info := p.Probe("127.0.0.1", l.Addr().(*net.TCPAddr).Port); info != nil {
+		t.Errorf("Probe() of a non-TLS service returned %+v.\n", info)
+	}
+}
+
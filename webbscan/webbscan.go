@@ -5,72 +5,331 @@ This is intended to be a simple(ish) port scanner tool, written as a
 demonstration of my programming abilities and as an exercise in learning the
 Go programming language.
 
+If the scan is interrupted (e.g., by typing Ctrl-C), the probes which are in
+progress are allowed to finish, and the results collected so far are written,
+marked as incomplete.  (A second interrupt terminates the tool immediately.)
+
+The tool can also compare the JSON results of two scans (e.g., last night's
+and tonight's), listing the ports on each host which have been opened or
+closed, which have changed between open and another state (e.g.,
+open|filtered), or on which a different service was detected:
+
+    webbscan diff old.json new.json
+
+The exit status is 0 if nothing changed, 1 if something did, and 255 if the
+results could not be compared.  (See also -baseline.)
+
+With -watch, the tool scans the same targets repeatedly, until it is
+interrupted, reporting only the transitions between successive scans (ports
+which have been opened or closed, which have changed between open and
+another state, or on which a different service was detected), each with the
+time at which it was observed.
+
+When scanning, the exit status is 0 on success, 1 if the results differ
+from the baseline (see -baseline), 2 if they violate the policy (see -policy;
+this takes precedence over a difference from the baseline), 130 if the scan
+was interrupted (which is how a watch ends), and 255 if the scan could not
+be performed (e.g., because of an invalid switch) or if the results, the
+comparison with the baseline, or the policy violations could not be written
+(this takes precedence over the others, and includes, with -watch, the
+results, comparisons, and transitions of every scan).
+
 The tool provides several command-line switches which control its execution:
 
+    -adaptive (default off):  adapt the number of concurrent probes (up to
+				-agents) and the probe rate (up to -rate) to
+				their outcomes:  both grow while the probes
+				succeed, and are halved when TCP probes time
+				out or probes fail; the values on which they
+				settled are reported at the end of the scan
     -agents (default 8):  the number of concurrent probes
-    -host (default "127.0.0.1"):  the target host to probe
+    -banner-timeout (default 2s):  the time to wait for a service to send
+				a banner (see -banners)
+    -banners (default off):  after connecting to an open TCP port, read
+				the first data which the service sends (e.g.,
+				an SSH or SMTP greeting) and include it in the
+				results
+    -baseline (default none):  the JSON results of a previous scan with
+				which to compare the results of this one, as
+				by the diff command; the changes are written
+				after the results (to the standard error, if
+				the results are not written as text), and the
+				exit status is 1 if there are any
+    -burst (default 1):  the maximum number of probes which may be sent at
+				once, at the start of the scan or after a lull,
+				when the rate is limited (see -rate)
+    -cert-expiry-warn (default 30d):  flag TLS certificates which have
+				expired or which expire within this period, in
+				days (e.g., "30d") or as a Go duration (e.g.,
+				"72h") (see -tls)
+    -checkpoint (default none):  a file in which to periodically record the
+				progress of the scan, so that it can be resumed
+				(see -resume)
+    -checkpoint-interval (default 30s):  the interval between updates of
+				the checkpoint file
+    -connect-timeout (default 3s):  the maximum time to wait for a TCP
+				connection to be established (0: no limit
+				other than the operating system's)
+    -host (default "127.0.0.1"):  the target hosts to probe, as a
+				comma-separated list of addresses, CIDR blocks
+				(e.g., "10.0.0.0/24"), address ranges (e.g.,
+				"10.0.0.5-40"), and host names
+    -http (default off):  request the root page from each open TCP port
+				(over TLS, or failing that, in the clear) and
+				report the status code, Server header, page
+				title, redirect location, and a hash of the
+				body (for a host specified by name, the page
+				of that name is requested, for virtual
+				hosting)
+    -http-timeout (default 5s):  the time to allow for each HTTP request
+				(see -http)
+    -iL (default none):  a file containing a list of target hosts to probe,
+				in the same form as for -host
+    -notify (default "-"):  where to send the transitions observed by
+				-watch:  "-" for the standard output, an "http"
+				or "https" URL for a webhook (which receives
+				them as JSON in a POST request), or otherwise
+				a file to which to append them
+    -o (default "text"):  the format of the results written to the
+				standard output ("text", "json", or "xml")
+    -oJ (default none):  a file to which to write the results in JSON
+				format (in addition to the standard output)
+    -oX (default none):  a file to which to write the results in nmap's
+				XML format (in addition to the standard output)
+    -policy (default none):  a JSON file (YAML is not supported)
+				describing the ports which are expected to be
+				open on each host (see package policy); the
+				results are checked against it, and any
+				violations (including hosts named by the policy
+				which were not scanned) are written after the
+				results (to the standard error, if the results
+				are not written as text)
+    -ports (default all):  the ports to probe, as a comma-separated list
+				of port numbers (e.g., "22"), ranges (e.g.,
+				"8000-8100", "-1024", or "60000-"), and
+				service names (e.g., "ssh")
     -protocol (default "tcp"):  Protocol ("tcp" or "udp")
     -rate (default unlimited):  the maximum number of probes to be sent
-				per second (0: unlimited)
+				per second (0: unlimited); the probes are paced
+				by a token bucket, which allows bursts of up to
+				-burst probes
+    -resume (default none):  a checkpoint file from a previous, interrupted
+				scan:  the probes recorded in it are not
+				repeated (the same -host, -iL, -ports, and
+				-protocol values should be given); unless
+				-checkpoint is specified, the file continues to
+				be updated
+    -retries (default 0):  the number of times to retry a probe which timed
+				out, waiting 100ms before the first retry and
+				twice as long before each subsequent one
+    -signatures (default built-in):  a file of service detection
+				signatures, in (a subset of) the format of
+				nmap's nmap-service-probes file (see -versions)
+    -ssh (default off):  on each open TCP port which speaks SSH, perform
+				the version exchange and enough of the key
+				exchange to report the server's version
+				string, supported algorithms, and host key
+				fingerprint (SHA256)
+    -ssh-timeout (default 5s):  the time to allow for each SSH exchange
+				(see -ssh)
+    -stream (default off):  write the result of each probe to the standard
+				output as it completes, as a line of JSON,
+				instead of writing the results at the end
+    -tls (default off):  attempt a TLS handshake with each open TCP port
+				and report the negotiated version, cipher
+				suite, and ALPN protocol, and the subject,
+				SANs, issuer, and expiry of each certificate
+				presented (for a host specified by name, the
+				name is sent to the service, for virtual
+				hosting)
+    -tls-timeout (default 3s):  the time to allow for each TLS handshake
+				(see -tls)
+    -udp-timeout (default 1s):  the time to wait for a response to a UDP
+				probe before reporting the port as
+				open|filtered
     -verbose (default none):	The level of verbosity for messages
 				(`-v` is a shorthand for "level 2")
+    -version-timeout (default 2s):  the time to wait for the response to
+				each service detection probe (see -versions)
+    -versions (default off):  identify the service (and, where possible,
+				the product and version) on each open port by
+				sending it probes and matching the responses
+				against a database of signatures
+    -watch (default off):  rescan the targets at this interval (e.g.,
+				"10m") until interrupted, reporting only the
+				transitions between successive scans (see
+				-notify); the first scan is compared with the
+				-baseline, if any, and the results of each
+				scan replace the contents of the -oJ and -oX
+				files, if any; the results are not otherwise
+				written, and -checkpoint, -policy, -resume, and
+				-stream cannot be used
 */
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/webbnh/DigitalOcean/checkpoint"
+	"github.com/webbnh/DigitalOcean/httpprobe"
+	"github.com/webbnh/DigitalOcean/notify"
+	"github.com/webbnh/DigitalOcean/policy"
+	"github.com/webbnh/DigitalOcean/portlist"
 	"github.com/webbnh/DigitalOcean/portprobe"
-	"github.com/webbnh/DigitalOcean/portserv"
 	"github.com/webbnh/DigitalOcean/progbar"
+	"github.com/webbnh/DigitalOcean/report"
+	"github.com/webbnh/DigitalOcean/scanner"
+	"github.com/webbnh/DigitalOcean/servprobe"
+	"github.com/webbnh/DigitalOcean/sshprobe"
+	"github.com/webbnh/DigitalOcean/targets"
+	"github.com/webbnh/DigitalOcean/tlsprobe"
 	"github.com/webbnh/DigitalOcean/vdiag"
 	"github.com/webbnh/DigitalOcean/workflow"
 )
 
-var progressBar *progbar.Bar = progbar.New(70, 65535, os.Stderr)
-
-// workItem represents an item to be passed to the workflow (it satisfies the
-// workflow.Item interface), in this case it contains the number of a port to
-// to be probed and a place to write the result.
-type workItem struct {
-	// Closure which invokes the appropriate probe function using the
-	// requested parameters (e.g., the host)
-	probeFunc func(*workItem)
-	// Port to be probed
-	port int
-	// Result of probe (e.g., open, closed, pending)
-	result portprobe.Result
-}
-
-// Do is the function which the workflow.Item interface uses to initiate the
-// work on the item.  Here it calls a closure which relieves us from having to
-// include more fields in the item.
-func (t workItem) Do(output chan<- workflow.Item) {
-	vdiag.Out(8, "In Do() for port %d\n", t.port)
-	t.probeFunc(&t)
-	progressBar.Spin()
-	output <- t
-	vdiag.Out(8, "Leaving Do() for port %d, result is %v\n",
-		t.port, t.result)
+// Maximum width of the progress bar on the screen, in columns
+const progressWidth = 70
+
+// Exit statuses used when the results differ from the baseline and when they
+// violate the policy
+const (
+	exitChanged   = 1
+	exitViolation = 2
+)
+
+// Exit status used when the scan is interrupted (by convention, 128 plus the
+// signal number of SIGINT)
+const exitInterrupted = 130
+
+// Exit status used when the scan cannot be performed or its results cannot be
+// written (reported as 255)
+const exitFailed = -1
+
+// Report writers, indexed by output format name
+var formats = map[string]func(*report.Scan, io.Writer) error{
+	"text": (*report.Scan).WriteText,
+	"json": (*report.Scan).WriteJSON,
+	"xml":  (*report.Scan).WriteXML,
 }
 
 func main() {
+	// The diff command compares the results of two previous scans.
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(diffMain(os.Args[2:]))
+	}
+
 	// Command line flags
 	var (
 		host     string
+		hostFile string
+		portSpec string
 		protocol string
 		agents   int
 		rate     int
+		burst    int
+		adaptive bool
+		format   string
+		jsonFile string
+		xmlFile  string
+		stream   bool
+		ckptFile string
+		ckptIntv time.Duration
+		resume   string
+		connTmo  time.Duration
+		udpTmo   time.Duration
+		retries  int
+		banners  bool
+		bnrTmo   time.Duration
+		versions bool
+		sigFile  string
+		verTmo   time.Duration
+		useTLS   bool
+		tlsTmo   time.Duration
+		certWarn = dayDuration(30 * 24 * time.Hour)
+		useHTTP  bool
+		httpTmo  time.Duration
+		useSSH   bool
+		sshTmo   time.Duration
+		baseFile string
+		polFile  string
+		watchInt time.Duration
+		notifyTo string
 	)
 
-	flag.StringVar(&host, "host", "127.0.0.1", "Host IP address")
+	flag.StringVar(&host, "host", "127.0.0.1",
+		"Target hosts (e.g., \"10.0.0.1,10.0.1.0/24,10.0.2.5-40,example.com\")")
+	flag.StringVar(&hostFile, "iL", "",
+		"File containing a list of target hosts")
+	flag.StringVar(&portSpec, "ports", "-",
+		"Ports to probe (e.g., \"22,80,8000-8100,-1024,https\")")
 	flag.StringVar(&protocol, "protocol", "tcp",
 		"Protocol (\"tcp\" or \"udp\")")
 	flag.IntVar(&agents, "agents", 8, "Number of concurrent probes")
 	flag.IntVar(&rate, "rate", 0, "Maximum number of probes per second (0: unlimited)")
+	flag.IntVar(&burst, "burst", 1,
+		"Maximum number of probes to send at once when rate-limited")
+	flag.BoolVar(&adaptive, "adaptive", false,
+		"Adapt the concurrency and rate to timeouts and errors")
+	flag.StringVar(&format, "o", "text",
+		"Output format (\"text\", \"json\", or \"xml\")")
+	flag.StringVar(&jsonFile, "oJ", "",
+		"File to which to write the results in JSON format")
+	flag.StringVar(&xmlFile, "oX", "",
+		"File to which to write the results in nmap XML format")
+	flag.BoolVar(&stream, "stream", false,
+		"Write each result as a line of JSON as soon as it is available")
+	flag.StringVar(&ckptFile, "checkpoint", "",
+		"File in which to record the progress of the scan")
+	flag.DurationVar(&ckptIntv, "checkpoint-interval", 30*time.Second,
+		"Interval between updates of the checkpoint file")
+	flag.StringVar(&resume, "resume", "",
+		"Checkpoint file from which to resume a scan")
+	flag.DurationVar(&connTmo, "connect-timeout", 3*time.Second,
+		"Maximum time to wait for a TCP connection (0: no limit)")
+	flag.DurationVar(&udpTmo, "udp-timeout", 1*time.Second,
+		"Time to wait for a response to a UDP probe")
+	flag.IntVar(&retries, "retries", 0,
+		"Number of times to retry a probe which timed out")
+	flag.BoolVar(&banners, "banners", false,
+		"Read and report the banner of each open TCP port")
+	flag.DurationVar(&bnrTmo, "banner-timeout", 2*time.Second,
+		"Time to wait for a service to send a banner")
+	flag.BoolVar(&versions, "versions", false,
+		"Identify the service on each open port")
+	flag.StringVar(&sigFile, "signatures", "",
+		"File of service detection signatures (default built-in)")
+	flag.DurationVar(&verTmo, "version-timeout", 2*time.Second,
+		"Time to wait for the response to each detection probe")
+	flag.BoolVar(&useTLS, "tls", false,
+		"Inspect the TLS session and certificates of each open TCP port")
+	flag.DurationVar(&tlsTmo, "tls-timeout", 3*time.Second,
+		"Time to allow for each TLS handshake")
+	flag.Var(&certWarn, "cert-expiry-warn",
+		"Flag certificates which expire within this period (e.g., \"30d\")")
+	flag.BoolVar(&useHTTP, "http", false,
+		"Fingerprint the web server on each open TCP port")
+	flag.DurationVar(&httpTmo, "http-timeout", 5*time.Second,
+		"Time to allow for each HTTP request")
+	flag.BoolVar(&useSSH, "ssh", false,
+		"Fingerprint the SSH server on each open TCP port")
+	flag.DurationVar(&sshTmo, "ssh-timeout", 5*time.Second,
+		"Time to allow for each SSH exchange")
+	flag.StringVar(&baseFile, "baseline", "",
+		"JSON results of a previous scan with which to compare the results")
+	flag.StringVar(&polFile, "policy", "",
+		"JSON file describing the ports expected to be open")
+	flag.DurationVar(&watchInt, "watch", 0,
+		"Interval at which to rescan, reporting transitions (0: scan once)")
+	flag.StringVar(&notifyTo, "notify", "-",
+		"Destination of transitions:  \"-\", a file, or a webhook URL")
 	flag.Parse()
 
 	switch protocol {
@@ -82,90 +341,698 @@ func main() {
 		os.Exit(-1)
 	}
 
-	vdiag.Out(1, "Scanning for open %s ports on %s using %d agents.\n",
-		protocol, host, agents)
+	if rate < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -rate value:  %d.\n", rate)
+		os.Exit(-1)
+	}
+	if burst < 1 {
+		fmt.Fprintf(os.Stderr, "Invalid -burst value:  %d.\n", burst)
+		os.Exit(-1)
+	}
+
+	if connTmo < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -connect-timeout value:  %v.\n",
+			connTmo)
+		os.Exit(-1)
+	}
+	if udpTmo <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -udp-timeout value:  %v.\n",
+			udpTmo)
+		os.Exit(-1)
+	}
+
+	if retries < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -retries value:  %d.\n",
+			retries)
+		os.Exit(-1)
+	}
+
+	if bnrTmo <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -banner-timeout value:  %v.\n",
+			bnrTmo)
+		os.Exit(-1)
+	}
+
+	if verTmo <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -version-timeout value:  %v.\n",
+			verTmo)
+		os.Exit(-1)
+	}
+
+	if tlsTmo <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -tls-timeout value:  %v.\n",
+			tlsTmo)
+		os.Exit(-1)
+	}
+	if certWarn <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -cert-expiry-warn value:  %v.\n",
+			&certWarn)
+		os.Exit(-1)
+	}
+
+	if httpTmo <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -http-timeout value:  %v.\n",
+			httpTmo)
+		os.Exit(-1)
+	}
+
+	if sshTmo <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -ssh-timeout value:  %v.\n",
+			sshTmo)
+		os.Exit(-1)
+	}
+
+	if watchInt < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid -watch value:  %v.\n", watchInt)
+		os.Exit(-1)
+	}
+	if watchInt > 0 && (stream || ckptFile != "" || resume != "" ||
+		polFile != "") {
+		fmt.Fprintln(os.Stderr, "-watch cannot be combined with "+
+			"-checkpoint, -policy, -resume, or -stream.")
+		os.Exit(-1)
+	}
+
+	if formats[format] == nil {
+		fmt.Fprintf(os.Stderr, "\"%s\" output format is not supported.\n",
+			format)
+		os.Exit(-1)
+	}
+
+	ports, err := portlist.Parse(portSpec, protocol)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -ports value:  %v.\n", err)
+		os.Exit(-1)
+	}
+
+	hosts, err := getTargets(host, hostFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid target hosts:  %v.\n", err)
+		os.Exit(-1)
+	}
+	if len(hosts) == 0 {
+		fmt.Fprintln(os.Stderr, "No target hosts were specified.")
+		os.Exit(-1)
+	}
+
+	var baseline *report.Scan
+	if baseFile != "" {
+		baseline, err = loadBaseline(baseFile, protocol)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid baseline:  %v.\n", err)
+			os.Exit(-1)
+		}
+	}
+
+	var pol *policy.Policy
+	if polFile != "" {
+		pol, err = policy.ReadFile(polFile, protocol)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid policy:  %v.\n", err)
+			os.Exit(-1)
+		}
+	}
+
+	vdiag.Out(1, "Scanning %d %s ports for open ports on %d hosts using %d agents.\n",
+		len(ports), protocol, len(hosts), agents)
 	if rate != 0 {
-		vdiag.Out(1, "Probe rate limited to %d probes per second.\n",
-			rate)
+		vdiag.Out(1, "Probe rate limited to %d probes per second "+
+			"(in bursts of up to %d).\n", rate, burst)
+	}
+	if adaptive {
+		vdiag.Out(1, "Adapting the number of agents and the probe rate "+
+			"to the outcomes of the probes.\n")
 	}
 	if vdiag.Verbosity() > 0 {
-		fmt.Printf("(Diagnostic messages verbosity level %d.)\n",
+		fmt.Fprintf(os.Stderr, "(Diagnostic messages verbosity level %d.)\n",
 			vdiag.Verbosity())
 	}
 
-	wfItems := [65535]workItem{}
-	wf := workflow.New(cap(wfItems), agents, rate)
+	s := scanner.Scanner{
+		Targets:  hosts,
+		Ports:    ports,
+		Protocol: protocol,
+		Agents:   agents,
+		Rate:     rate,
+		Burst:    burst,
+		Prober: portprobe.Prober{
+			ConnectTimeout: connTmo,
+			UDPTimeout:     udpTmo,
+			Retries:        retries,
+			Banners:        banners,
+			BannerTimeout:  bnrTmo,
+		},
+		// An interrupt stops the dispatch of probes, but those in
+		// progress are allowed to finish.
+		ProbeContext: context.Background(),
+	}
+	if adaptive {
+		s.Controller = workflow.NewController(1, agents)
+	}
 
-	progressBar.Paint()
+	if versions {
+		s.Detector = &servprobe.Detector{Timeout: verTmo}
+		if sigFile != "" {
+			s.Detector.Signatures, err = servprobe.ReadFile(sigFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid signatures:  %v.\n",
+					err)
+				os.Exit(-1)
+			}
+		}
+	}
+
+	if useTLS {
+		s.TLS = &tlsprobe.Prober{Timeout: tlsTmo,
+			ExpiryWarn: time.Duration(certWarn)}
+	}
+	if useHTTP {
+		s.HTTP = &httpprobe.Prober{Timeout: httpTmo}
+	}
+	if useSSH {
+		s.SSH = &sshprobe.Prober{Timeout: sshTmo}
+	}
+
+	if watchInt > 0 {
+		os.Exit(watch(&s, watchInt, baseline, notify.New(notifyTo),
+			jsonFile, xmlFile))
+	}
+
+	// If resuming, skip the probes which were already completed, and,
+	// unless told otherwise, keep recording progress in the same file.
+	var state *checkpoint.State
+	switch {
+	case resume != "":
+		state, err = loadCheckpoint(resume, protocol)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to resume:  %v.\n", err)
+			os.Exit(-1)
+		}
+		s.Exclude = func(t targets.Target, port int) bool {
+			return state.Done(t.Addr, port)
+		}
+		if ckptFile == "" {
+			ckptFile = resume
+		}
+	case ckptFile != "":
+		state = checkpoint.New(protocol)
+	}
+	size := s.Size()
+	if resume != "" {
+		vdiag.Out(1, "Resuming scan:  %d probes remaining.\n", size)
+	}
+
+	width := progressWidth
+	if size < width {
+		width = size
+	}
+	progressBar := progbar.New(width, size, os.Stderr)
+
+	var results *report.Stream
+	if stream {
+		results = report.NewStream(os.Stdout)
+	}
 
 	start := time.Now()
-	// Request a scan of each (and all) of the ports.
-	for i := range wfItems {
-		wfItems[i].port = i + 1 // Initialize for later
-
-		// Capture the host and port to be probed, as well as the
-		// place to record the result, using a closure.
-		port := wfItems[i].port
-		wfItems[i].probeFunc = func(item *workItem) {
-			vdiag.Out(7, "Calling probe for %s:%d\n", host, port)
-			item.result = portprobe.Probe(protocol, host, port)
-		}
-
-		// Send the item off to be independently executed.
-		vdiag.Out(6, "Queuing item %d.\n", i)
-		wf.Enqueue(wfItems[i])
-	}
-
-	// Wait for the scans to complete.
-	for i := range wfItems {
-		progressBar.Update()
-
-		// Since the items are executed concurrently, they may
-		// complete out of order.  We're done when all the scans have
-		// finished, so check each one.  If the target item is not
-		// complete, delay by trying to get a completed one.  What we
-		// actually get back is a copy, so propagate its result into
-		// the appropriate slot in the array.
-		for !wfItems[i].result.IsComplete() {
-			vdiag.Out(5, "Scan of port %d is not ready, waiting...",
-				wfItems[i].port)
-			item := wf.Dequeue().(workItem)
-			wfItems[item.port-1].result = item.result
-			vdiag.Out(5, "got %v.\n", item)
-		}
-	}
-
-	elapsed := time.Now().Sub(start)
-	progressBar.Done()
-
-	// Print the result
-	printedHeader := false
-	for _, v := range wfItems {
-		if v.result.IsOpen() {
-			if !printedHeader {
-				fmt.Printf("Open %s ports on %s:\n",
-					protocol, host)
-				printedHeader = true
+	rpt, rptHosts := newReport(protocol, ports, hosts, start)
+	if resume != "" {
+		addResumed(rpt, rptHosts, ports, state)
+	}
+
+	if progressBar != nil {
+		progressBar.Paint()
+	}
+
+	// Cancel the scan on interrupt, and then restore the default handling
+	// so that another interrupt terminates the program.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	scan, err := s.Scan(ctx)
+	if err != nil {
+		if progressBar != nil {
+			progressBar.Done()
+		}
+		fmt.Fprintf(os.Stderr, "Unable to scan:  %v.\n", err)
+		os.Exit(-1)
+	}
+
+	// Collect the results as the scans complete.
+	lastSave := time.Now()
+	failed := false // Whether the results could not all be written
+	for r := range scan {
+		if progressBar != nil {
+			progressBar.Spin()
+			progressBar.Update()
+		}
+		vdiag.Out(5, "Got %v.\n", r)
+
+		p := newPort(r)
+		rpt.AddPort(rptHosts[r.Target.Addr], p)
+		if state != nil {
+			state.Add(r.Target.Name, r.Target.Addr, p)
+			if time.Since(lastSave) >= ckptIntv {
+				saveCheckpoint(state, ckptFile)
+				lastSave = time.Now()
 			}
-			portStr := portserv.Tcp(v.port)
-			if portStr == "" {
-				fmt.Println(v.port)
-			} else {
-				fmt.Printf("%d (%s)\n", v.port, portStr)
+		}
+		if results != nil {
+			err := results.WritePort(r.Target.Name, r.Target.Addr,
+				p)
+			if err != nil {
+				fmt.Fprintf(os.Stderr,
+					"Unable to write result:  %v.\n", err)
+				failed = true
 			}
 		}
 	}
-	if !printedHeader {
-		fmt.Printf("No open %s ports on %s.\n", protocol, host)
+
+	end := time.Now()
+	elapsed := end.Sub(start)
+	if progressBar != nil {
+		progressBar.Done()
+	}
+	if state != nil {
+		saveCheckpoint(state, ckptFile)
+	}
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		fmt.Fprintln(os.Stderr, "Scan interrupted.")
+		rpt.Complete = false
 	}
+	rpt.Finish(end)
 
-	wf.Destroy()
+	// When streaming, the results have already been written.
+	if !stream {
+		if err := formats[format](rpt, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write results:  %v.\n",
+				err)
+			failed = true
+		}
+	}
+	if !writeOutFiles(rpt, jsonFile, xmlFile) {
+		failed = true
+	}
+
+	// Compare the results with the baseline and check them against the
+	// policy, unless they are incomplete, in which case the ports which
+	// weren't probed would appear to be closed.  Any findings follow the
+	// results, unless that would spoil their format.
+	summary := os.Stdout
+	if stream || format != "text" {
+		summary = os.Stderr
+	}
+	changed, violated := false, false
+	switch {
+	case baseline == nil && pol == nil:
+	case interrupted:
+		fmt.Fprintln(os.Stderr, "The results were not compared with "+
+			"the baseline or checked against the policy, since "+
+			"the scan was interrupted.")
+	default:
+		if baseline != nil {
+			changed, err = writeChanges(summary, baseline, rpt)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to compare with "+
+					"the baseline:  %v.\n", err)
+				failed = true
+			}
+		}
+		if pol != nil {
+			violations := pol.Check(rpt)
+			violated = len(violations) > 0
+			err := policy.WriteViolations(summary, violations)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to write policy "+
+					"violations:  %v.\n", err)
+				failed = true
+			}
+		}
+	}
+
+	if s.Controller != nil {
+		fmt.Fprintf(os.Stderr, "Adaptive control settled on %s.\n",
+			settled(s.Controller))
+	}
 	vdiag.Out(1, "Elapsed time: %v.\n", elapsed)
-	if time.Duration(len(wfItems))*time.Second > elapsed {
+	switch {
+	case size == 0:
+		break
+	case time.Duration(size)*time.Second > elapsed:
 		vdiag.Out(1, "Average probe rate: %d probes/second.\n",
-			time.Duration(len(wfItems))*time.Second/elapsed)
-	} else {
+			time.Duration(size)*time.Second/elapsed)
+	default:
 		vdiag.Out(1, "Average probe rate: %v/probe.\n",
-			elapsed/time.Duration(len(wfItems)))
+			elapsed/time.Duration(size))
+	}
+
+	switch {
+	case failed:
+		os.Exit(exitFailed)
+	case interrupted:
+		os.Exit(exitInterrupted)
+	case violated:
+		os.Exit(exitViolation)
+	case changed:
+		os.Exit(exitChanged)
+	}
+}
+
+// watch scans repeatedly, starting each scan the specified interval after the
+// start of the previous one (or immediately, if that took longer), until
+// interrupted, and returns the exit status.  The transitions between each
+// scan and the previous one (or the baseline, if any, for the first scan) are
+// delivered to the notifier, and the results of each scan are written to the
+// JSON and XML files, if any.  If the results of any scan could not be
+// written, compared, or delivered, the watch continues, but the exit status
+// is that of a failed scan.
+func watch(s *scanner.Scanner, interval time.Duration,
+	previous *report.Scan, n notify.Notifier, jsonFile, xmlFile string) int {
+	vdiag.Out(1, "Rescanning every %v.\n", interval)
+
+	// Stop watching on interrupt, and then restore the default handling
+	// so that another interrupt terminates the program.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	failed := false
+	for pass := 1; ; pass++ {
+		start := time.Now()
+		vdiag.Out(1, "Starting scan %d.\n", pass)
+		rpt, rptHosts := newReport(s.Protocol, s.Ports, s.Targets, start)
+		scan, err := s.Scan(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to scan:  %v.\n", err)
+			return -1
+		}
+		for r := range scan {
+			vdiag.Out(5, "Got %v.\n", r)
+			rpt.AddPort(rptHosts[r.Target.Addr], newPort(r))
+		}
+
+		// An incomplete scan is discarded, since the ports which
+		// weren't probed would appear to be closed.
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "Watch interrupted.")
+			return watchStatus(failed)
+		}
+		end := time.Now()
+		rpt.Finish(end)
+		vdiag.Out(1, "Finished scan %d in %v.\n", pass, end.Sub(start))
+		if s.Controller != nil {
+			vdiag.Out(1, "Adaptive control settled on %s.\n",
+				settled(s.Controller))
+		}
+		if !writeOutFiles(rpt, jsonFile, xmlFile) {
+			vdiag.Out(0, "The results of scan %d were not written.\n",
+				pass)
+			failed = true
+		}
+
+		if previous != nil {
+			changes, err := report.Diff(previous, rpt)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to compare "+
+					"results:  %v.\n", err)
+				failed = true
+			}
+			transitions := make([]notify.Transition, len(changes))
+			for i, c := range changes {
+				transitions[i] = notify.Transition{Time: end,
+					Change: c}
+			}
+			if len(transitions) > 0 {
+				if err := n.Notify(transitions); err != nil {
+					fmt.Fprintf(os.Stderr, "Unable to "+
+						"notify:  %v.\n", err)
+					failed = true
+				}
+			}
+		}
+		previous = rpt
+
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(os.Stderr, "Watch interrupted.")
+			return watchStatus(failed)
+		case <-time.After(time.Until(start.Add(interval))):
+		}
+	}
+}
+
+// watchStatus returns the exit status of a watch which has been interrupted,
+// given whether the results of any of its scans could not be written.
+func watchStatus(failed bool) int {
+	if failed {
+		return exitFailed
+	}
+	return exitInterrupted
+}
+
+// settled describes the concurrency and the rate on which the controller has
+// settled.
+func settled(c *workflow.Controller) string {
+	desc := fmt.Sprintf("%d agents", c.Limit())
+	if rate := c.Rate(); rate > 0 {
+		desc += fmt.Sprintf(" and %d probes per second", rate)
+	}
+	return desc
+}
+
+// diffMain implements the diff command, which compares the results of two
+// previous scans, and returns the exit status.
+func diffMain(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage:  webbscan diff old.json new.json")
+		return -1
+	}
+
+	var scans [2]*report.Scan
+	for i, name := range args {
+		s, err := report.ReadFile(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read results:  %v.\n",
+				err)
+			return -1
+		}
+		if !s.Complete {
+			fmt.Fprintf(os.Stderr, "Warning:  the scan recorded in "+
+				"%s was interrupted; ports which it did not "+
+				"probe may appear to be closed.\n", name)
+		}
+		scans[i] = s
+	}
+
+	changed, err := writeChanges(os.Stdout, scans[0], scans[1])
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Unable to compare results:  %v.\n", err)
+		return -1
+	case changed:
+		return exitChanged
+	}
+	return 0
+}
+
+// writeChanges compares the results of two scans, writes the changes, and
+// returns a boolean indicating whether there were any.
+func writeChanges(w io.Writer, before, after *report.Scan) (bool, error) {
+	changes, err := report.Diff(before, after)
+	if err != nil {
+		return false, err
+	}
+	return len(changes) > 0, report.WriteDiff(w, changes)
+}
+
+// loadBaseline reads the results of a previous scan, checking that they are
+// comparable with those of the current one.
+func loadBaseline(name, protocol string) (*report.Scan, error) {
+	baseline, err := report.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if baseline.Protocol != protocol {
+		return nil, fmt.Errorf("%s: the previous scan used protocol "+
+			"\"%s\"", name, baseline.Protocol)
+	}
+	return baseline, nil
+}
+
+// getTargets assembles the list of target hosts from the -host and -iL
+// command line flags.  The -host value is used only if there is no -iL value
+// or if the -host flag was explicitly specified.
+func getTargets(hostSpec, hostFile string) ([]targets.Target, error) {
+	useHost := hostFile == ""
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "host" {
+			useHost = true
+		}
+	})
+
+	var fromHost, fromFile []targets.Target
+	var err error
+	if useHost {
+		if fromHost, err = targets.Parse(hostSpec); err != nil {
+			return nil, err
+		}
+	}
+	if hostFile != "" {
+		if fromFile, err = targets.ReadFile(hostFile); err != nil {
+			return nil, err
+		}
+	}
+	return targets.Merge(fromHost, fromFile), nil
+}
+
+// loadCheckpoint reads the record of the progress of a previous scan,
+// checking that it is compatible with the current one.
+func loadCheckpoint(name, protocol string) (*checkpoint.State, error) {
+	state, err := checkpoint.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	if state.Protocol != protocol {
+		return nil, fmt.Errorf("%s: the previous scan used protocol "+
+			"\"%s\"", name, state.Protocol)
+	}
+	return state, nil
+}
+
+// saveCheckpoint records the progress of the scan, reporting any failure.
+func saveCheckpoint(state *checkpoint.State, name string) {
+	vdiag.Out(3, "Saving checkpoint (%d results) to %s.\n",
+		len(state.Results), name)
+	if err := state.Save(name); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to save checkpoint:  %v.\n", err)
+	}
+}
+
+// addResumed adds the results recorded in the checkpoint from a previous scan
+// to the report, ignoring any for hosts or ports which are not part of the
+// current scan.
+func addResumed(rpt *report.Scan, rptHosts map[string]*report.Host,
+	ports []int, state *checkpoint.State) {
+	portSet := make(map[int]bool)
+	for _, p := range ports {
+		portSet[p] = true
+	}
+
+	for _, e := range state.Results {
+		if h := rptHosts[e.Addr]; h != nil && portSet[e.Port.Port] {
+			rpt.AddPort(h, e.Port)
+		}
+	}
+}
+
+// newReport prepares the report of a scan, with an entry for each host, in
+// order, and returns it along with the entries, indexed by address.
+func newReport(protocol string, ports []int, hosts []targets.Target,
+	start time.Time) (*report.Scan, map[string]*report.Host) {
+	rpt := report.New("webbscan", protocol, ports, start)
+	rpt.Flags = flagValues()
+	rptHosts := make(map[string]*report.Host)
+	for _, h := range hosts {
+		rptHosts[h.Addr] = rpt.AddHost(h.Name, h.Addr)
+	}
+	return rpt, rptHosts
+}
+
+// newPort returns the entry in the report for the result of a probe.
+func newPort(r scanner.PortResult) report.Port {
+	p := report.NewPort(r.Protocol, r.Port, r.Result)
+	p.Reason, p.Attempts = r.Reason, r.Attempts
+	p.Banner = report.Sanitize(r.Banner)
+	p.Detected = r.Service
+	p.TLS = r.TLS
+	p.HTTP = r.HTTP
+	p.SSH = r.SSH
+	return p
+}
+
+// writeOutFiles writes the results to the JSON and XML files, if any,
+// reporting any failure, and returns a boolean indicating whether they were
+// all written.
+func writeOutFiles(rpt *report.Scan, jsonFile, xmlFile string) bool {
+	outFiles := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{jsonFile, rpt.WriteJSON},
+		{xmlFile, rpt.WriteXML},
+	}
+	ok := true
+	for _, f := range outFiles {
+		if f.name == "" {
+			continue
+		}
+		if err := writeFile(f.name, f.write); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write results:  %v.\n",
+				err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// flagValues returns the values of all of the command line flags, indexed by
+// flag name.
+func flagValues() map[string]string {
+	values := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// writeFile creates the specified file and writes to it using the specified
+// function.
+func writeFile(name string, write func(io.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// dayDuration is a command line flag value which is a duration, expressed
+// either in days (e.g., "30d") or in the form accepted by time.ParseDuration
+// (e.g., "72h").
+type dayDuration time.Duration
+
+// Set parses the flag value.
+func (d *dayDuration) Set(value string) error {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return fmt.Errorf("invalid number of days \"%s\"", days)
+		}
+		*d = dayDuration(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = dayDuration(v)
+	return nil
+}
+
+// String returns the flag value as a string, in days if it is a whole number
+// of them.
+func (d *dayDuration) String() string {
+	v := time.Duration(*d)
+	if v != 0 && v%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", v/(24*time.Hour))
 	}
+	return v.String()
 }
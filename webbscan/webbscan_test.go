@@ -3,66 +3,44 @@ package main
 
 import (
 	"testing"
-
-	"github.com/webbnh/DigitalOcean/portprobe"
-	"github.com/webbnh/DigitalOcean/workflow"
+	"time"
 )
 
-func TestDo(t *testing.T) {
+// Test the main function
+func TestWebbscan(t *testing.T) {
+	t.Log("I punted on unit-testing main() -- " +
+		"I'll leave that to the \"integration\" suite.\n")
+}
+
+func TestDayDuration(t *testing.T) {
 	cases := []struct {
-		testPort  int
-		expResult portprobe.Result
+		value    string
+		expected time.Duration
+		str      string
 	}{
-		{1, -1},
-		{2, 1},
-		{3, 0},
-		{8, 1},
-		{5, -1},
+		{"30d", 30 * 24 * time.Hour, "30d"},
+		{"0d", 0, "0s"},
+		{"72h", 72 * time.Hour, "3d"},
+		{"90m", 90 * time.Minute, "1h30m0s"},
 	}
 
 	for _, v := range cases {
-		item := workItem{
-			probeFunc: func(i *workItem) {
-				v := v // Capture for the closure.
-				if i.port != v.testPort {
-					t.Errorf("Got port %d; expected %d "+
-						"(case %v).\n",
-						i.port, v.testPort, v)
-				}
-				i.result = v.expResult
-			},
-			port: v.testPort,
-		}
-		outChan := make(chan workflow.Item, 10)
-
-		item.Do(outChan)
-
-		switch len(outChan) {
-		case 0:
-			t.Errorf("Work item %v failed to produce an output.\n",
-				v)
+		var d dayDuration
+		if err := d.Set(v.value); err != nil {
+			t.Errorf("Set(\"%s\") returned \"%v\".\n", v.value, err)
 			continue
-		default:
-			t.Errorf("Work item %v produced %d outputs "+
-				"(expected only 1).\n",
-				v, len(outChan))
-			continue
-		case 1: // Expected result; execute the rest of the loop
-			break
 		}
-
-		outItem := (<-outChan).(workItem)
-
-		if outItem.result != v.expResult {
-			t.Errorf("Got result \"%v\"; expected \"%v\" "+
-				"(case %v).\n",
-				outItem.result, v.expResult, v)
+		if time.Duration(d) != v.expected || d.String() != v.str {
+			t.Errorf("Set(\"%s\") produced %v (\"%s\"); expected "+
+				"%v (\"%s\").\n", v.value, time.Duration(d), &d,
+				v.expected, v.str)
 		}
 	}
-}
 
-// Test the main function
-func TestWebbscan(t *testing.T) {
-	t.Log("I punted on unit-testing main() -- " +
-		"I'll leave that to the \"integration\" suite.\n")
+	for _, value := range []string{"", "d", "xd", "1.5d", "30"} {
+		var d dayDuration
+		if err := d.Set(value); err == nil {
+			t.Errorf("Set(\"%s\") unexpectedly succeeded.\n", value)
+		}
+	}
 }
@@ -0,0 +1,149 @@
+package workflow
+
+import (
+	"context"
+	"sync"
+)
+
+// Controller adapts the number of Items which a workflow executes
+// concurrently (and, optionally, the rate at which it starts them) to the
+// outcomes of the Items, in the manner of TCP's congestion control (additive
+// increase, multiplicative decrease):  starting from the minimum, the limit
+// grows as long as the outcomes are healthy -- doubling with each "window"
+// of Items until the first unhealthy outcome, and then by one per window --
+// and it is halved whenever an unhealthy outcome is reported (but at most
+// once per window, since the Items which were already in progress are likely
+// to suffer from the same trouble).  A Controller is safe for concurrent use.
+type Controller struct {
+	// Bounds of the concurrency limit
+	min, max int
+
+	// Serializes access to the state of the controller
+	mu sync.Mutex
+	// Current concurrency limit (fractional, so that it can grow
+	// gradually)
+	window float64
+	// Whether no unhealthy outcome has been reported yet
+	slowStart bool
+	// Number of outcomes reported since the limit was last decreased
+	sinceCut int
+	// Number of Items in progress
+	active int
+	// Closed (and replaced) to wake the callers waiting in Acquire
+	wake chan struct{}
+	// Limiter whose rate is adapted along with the limit, if any, and its
+	// maximum rate
+	limiter *Limiter
+	maxRate int
+}
+
+// NewController creates a new Controller, specifying the minimum and maximum
+// numbers of Items to be executed concurrently (the minimum is at least one,
+// and the maximum at least the minimum).  The limit starts at the minimum.
+func NewController(minLimit, maxLimit int) *Controller {
+	minLimit = max(minLimit, 1)
+	maxLimit = max(maxLimit, minLimit)
+	return &Controller{
+		min:       minLimit,
+		max:       maxLimit,
+		window:    float64(minLimit),
+		slowStart: true,
+		sinceCut:  maxLimit,
+		wake:      make(chan struct{}),
+	}
+}
+
+// SetLimiter specifies a Limiter whose rate the Controller adapts in
+// proportion to the concurrency limit, up to the Limiter's current rate.  It
+// should be called before the Controller is put to use.
+func (c *Controller) SetLimiter(limiter *Limiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limiter = limiter
+	c.maxRate = limiter.Rate()
+	c.adjust()
+}
+
+// Limit returns the current concurrency limit.
+func (c *Controller) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.window)
+}
+
+// Rate returns the current rate of the Limiter, if any (or zero).
+func (c *Controller) Rate() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limiter.Rate()
+}
+
+// Acquire waits until fewer Items than the limit are in progress, and then
+// counts the caller's Item as being in progress.  If the context is done
+// first, Acquire returns its error.
+func (c *Controller) Acquire(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.active < int(c.window) {
+			c.active++
+			c.mu.Unlock()
+			return nil
+		}
+		wake := c.wake
+		c.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release indicates that an Item counted by Acquire is no longer in progress.
+func (c *Controller) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	c.wakeAll()
+}
+
+// Report reports the outcome of an Item, adjusting the limit accordingly:  an
+// unhealthy outcome is one which suggests that the work is being done too
+// quickly (e.g., a timeout).
+func (c *Controller) Report(healthy bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sinceCut++
+	switch {
+	case healthy && c.slowStart:
+		c.window++
+	case healthy:
+		c.window += 1 / c.window
+	case c.sinceCut < int(c.window):
+		// The limit was decreased recently.
+		return
+	default:
+		c.window /= 2
+		c.slowStart = false
+		c.sinceCut = 0
+	}
+	c.adjust()
+}
+
+// adjust keeps the limit within its bounds, adapts the rate of the Limiter,
+// if any, and wakes the callers waiting in Acquire, in case the limit grew.
+func (c *Controller) adjust() {
+	c.window = min(max(c.window, float64(c.min)), float64(c.max))
+	if c.limiter != nil {
+		rate := c.maxRate * int(c.window) / c.max
+		c.limiter.SetRate(max(rate, 1))
+	}
+	c.wakeAll()
+}
+
+// wakeAll wakes the callers waiting in Acquire.
+func (c *Controller) wakeAll() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
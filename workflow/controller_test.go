@@ -0,0 +1,121 @@
+// Unit tests for the Controller of package workflow.
+package workflow
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Test NewController() and the adaptation of the limit by Report().
+func TestControllerReport(t *testing.T) {
+	c := NewController(2, 20)
+	l := NewLimiter(1000, 1)
+	c.SetLimiter(l)
+
+	steps := []struct {
+		healthy []bool
+		limit   int
+		rate    int
+	}{
+		{nil, 2, 100},
+		// Slow start:  one more per healthy outcome
+		{[]bool{true, true}, 4, 200},
+		{[]bool{true, true, true, true}, 8, 400},
+		// The first unhealthy outcome halves the limit, but the next
+		// ones, in the same window, are ignored.
+		{[]bool{false, false, false}, 4, 200},
+		// Additive increase:  one more per window
+		{[]bool{true, true, true, true, true}, 5, 250},
+		// After a window, another unhealthy outcome counts.
+		{[]bool{false}, 2, 100},
+		// The limit stays within its bounds.
+		{[]bool{true, true, false}, 2, 100},
+	}
+	for i, v := range steps {
+		for _, healthy := range v.healthy {
+			c.Report(healthy)
+		}
+		if c.Limit() != v.limit || c.Rate() != v.rate ||
+			l.Rate() != v.rate {
+			t.Errorf("Step %d:  got limit %d and rate %d (%d); "+
+				"expected %d and %d.\n", i, c.Limit(), c.Rate(),
+				l.Rate(), v.limit, v.rate)
+		}
+	}
+
+	bounds := []struct {
+		min, max int
+		limit    int
+	}{
+		{0, 0, 1},
+		{1, 4, 4},
+		{5, 3, 5},
+	}
+	for i, v := range bounds {
+		c := NewController(v.min, v.max)
+		for j := 0; j < 10; j++ {
+			c.Report(true)
+		}
+		if c.Limit() != v.limit || c.Rate() != 0 {
+			t.Errorf("Case %d:  got limit %d and rate %d; expected %d "+
+				"and 0.\n", i, c.Limit(), c.Rate(), v.limit)
+		}
+	}
+}
+
+// Test that a workflow with a Controller executes no more Items at once than
+// the limit allows.
+func TestControllerWorkflow(t *testing.T) {
+	const itemCount = 50
+
+	c := NewController(3, 3)
+	var active, peak int32
+	var mu sync.Mutex
+	p := NewPool(context.Background(), itemCount, 10, 0,
+		func(ctx context.Context, n int) (int, error) {
+			a := atomic.AddInt32(&active, 1)
+			mu.Lock()
+			peak = max(peak, a)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&active, -1)
+			return n, nil
+		})
+	defer p.Destroy()
+	p.SetController(c)
+	for i := 1; i <= itemCount; i++ {
+		p.Submit(i)
+	}
+	for i := 1; i <= itemCount; i++ {
+		if _, ok := p.Next(); !ok {
+			t.Fatalf("Next() of result #%d failed.\n", i)
+		}
+	}
+	if peak != 3 {
+		t.Errorf("Up to %d items were executed at once; expected 3.\n",
+			peak)
+	}
+}
+
+// Test that Acquire() gives up when the context is done.
+func TestControllerAcquire(t *testing.T) {
+	c := NewController(1, 1)
+	if err := c.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire() returned \"%v\".\n", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	if err := c.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Acquire() beyond the limit returned \"%v\".\n", err)
+	}
+
+	c.Release()
+	if err := c.Acquire(context.Background()); err != nil {
+		t.Errorf("Acquire() after Release() returned \"%v\".\n", err)
+	}
+}
@@ -0,0 +1,141 @@
+package workflow
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token-bucket rate limiter:  tokens are added to the bucket at
+// a steady rate, up to its capacity (the burst size), and each event takes
+// one, waiting for it if the bucket is empty.  Thus, after a lull, up to the
+// burst size of events can proceed at once, but over the long term the events
+// proceed no faster than the rate.  A Limiter is safe for concurrent use, and
+// a nil Limiter imposes no limit.
+type Limiter struct {
+	// Serializes access to the parameters and state of the bucket
+	mu sync.Mutex
+	// Rate at which tokens are added to the bucket, per second, and the
+	// corresponding interval between their additions
+	rate     int
+	interval time.Duration
+	// Capacity of the bucket
+	burst int
+	// Time by which the bucket may run ahead of the steady rate (i.e.,
+	// the interval times one less than the burst size)
+	tolerance time.Duration
+	// Time at which the bucket will be full, if no more tokens are taken
+	// (this represents the number of tokens in the bucket:  the later the
+	// time, the fewer)
+	full time.Time
+}
+
+// Instance of the clock which can be overridden for unit testing
+var nowFunc = time.Now
+
+// NewLimiter creates a new Limiter, specifying the maximum number of events
+// to allow per second and the maximum number to allow at once (a burst size
+// of zero is treated as one).  The bucket starts full.  If the rate is not
+// positive, NewLimiter returns nil, which imposes no limit.
+func NewLimiter(rate, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	l := &Limiter{burst: burst}
+	l.setRate(rate)
+	return l
+}
+
+// Rate returns the maximum number of events allowed per second.
+func (l *Limiter) Rate() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// SetRate changes the maximum number of events allowed per second (which must
+// be positive).
+func (l *Limiter) SetRate(rate int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setRate(rate)
+}
+
+// setRate sets the rate and the parameters which depend on it.
+func (l *Limiter) setRate(rate int) {
+	l.rate = rate
+	l.interval = time.Second / time.Duration(rate)
+	l.tolerance = l.interval * time.Duration(l.burst-1)
+}
+
+// Allow takes a token from the bucket, if one is available, and returns a
+// boolean indicating whether it did.
+func (l *Limiter) Allow() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := nowFunc()
+	if l.delay(now) > 0 {
+		return false
+	}
+	l.take(now)
+	return true
+}
+
+// Wait takes a token from the bucket, waiting until one is available, and
+// returns a boolean indicating whether it had to wait.  If the context is
+// done first, Wait returns its error, and no token is taken (or the token is
+// returned to the bucket).
+func (l *Limiter) Wait(ctx context.Context) (bool, error) {
+	if err := ctx.Err(); l == nil || err != nil {
+		return false, err
+	}
+
+	// Reserve the next token, and then wait for it to become available.
+	l.mu.Lock()
+	now := nowFunc()
+	delay := l.delay(now)
+	l.take(now)
+	l.mu.Unlock()
+	if delay <= 0 {
+		return false, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.full = l.full.Add(-l.interval)
+		l.mu.Unlock()
+		return true, ctx.Err()
+	}
+}
+
+// delay returns the time which must pass, after the specified time, before a
+// token is available (zero or less if one is available already).
+func (l *Limiter) delay(now time.Time) time.Duration {
+	if !l.full.After(now) {
+		return 0 // The bucket is full.
+	}
+	return l.full.Sub(now) - l.tolerance
+}
+
+// take removes a token from the bucket at the specified time (possibly
+// reserving one which is not yet available).
+func (l *Limiter) take(now time.Time) {
+	if l.full.Before(now) {
+		l.full = now
+	}
+	l.full = l.full.Add(l.interval)
+}
@@ -0,0 +1,120 @@
+// Unit tests for the Limiter of package workflow.
+package workflow
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Test NewLimiter() and Allow(), using a clock which only moves when told to.
+func TestLimiterAllow(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	l := NewLimiter(10, 3)
+	cases := []struct {
+		advance time.Duration
+		allowed int
+	}{
+		{0, 3},                      // The bucket starts full.
+		{50 * time.Millisecond, 0},  // Half a token has been added.
+		{50 * time.Millisecond, 1},  // Now a whole one has.
+		{250 * time.Millisecond, 2}, // Two and a half tokens...
+		{time.Minute, 3},            // The bucket holds only three.
+	}
+	for i, v := range cases {
+		now = now.Add(v.advance)
+		allowed := 0
+		for l.Allow() {
+			allowed++
+		}
+		if allowed != v.allowed {
+			t.Errorf("Case %d:  %d events were allowed; expected "+
+				"%d.\n", i, allowed, v.allowed)
+		}
+	}
+
+	// A bucket with no burst size holds a single token.
+	l = NewLimiter(10, 0)
+	if !l.Allow() || l.Allow() {
+		t.Error("A limiter with no burst size allowed other than one " +
+			"event.")
+	}
+
+	// A limiter without a rate is nil, which allows everything.
+	l = NewLimiter(0, 3)
+	if l != nil {
+		t.Errorf("NewLimiter() without a rate returned %+v.\n", l)
+	}
+	for i := 0; i < 10; i++ {
+		if !l.Allow() {
+			t.Fatal("A nil limiter did not allow an event.")
+		}
+	}
+	if throttled, err := l.Wait(context.Background()); throttled ||
+		err != nil {
+		t.Errorf("Wait() on a nil limiter returned %v, \"%v\".\n",
+			throttled, err)
+	}
+}
+
+// Test Wait(), with the real clock.
+func TestLimiterWait(t *testing.T) {
+	const interval = 20 * time.Millisecond
+
+	l := NewLimiter(int(time.Second/interval), 2)
+	start := time.Now()
+	for i := 1; i <= 4; i++ {
+		throttled, err := l.Wait(context.Background())
+		if err != nil {
+			t.Fatalf("Wait() returned \"%v\".\n", err)
+		}
+		if throttled != (i > 2) {
+			t.Errorf("Wait() #%d returned throttled = %v.\n", i,
+				throttled)
+		}
+	}
+
+	// The first two events proceed at once, and the others at the rate.
+	if elapsed := time.Since(start); elapsed < 2*interval-time.Millisecond {
+		t.Errorf("Four events took only %v.\n", elapsed)
+	}
+}
+
+// Test that Wait() returns the token when the context is done first.
+func TestLimiterCancel(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	l := NewLimiter(1, 1)
+	if !l.Allow() {
+		t.Fatal("The first event was not allowed.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Wait(ctx); err != context.Canceled {
+		t.Errorf("Wait() returned \"%v\"; expected \"%v\".\n", err,
+			context.Canceled)
+	}
+
+	// Had the token not been returned, none would be available yet.
+	now = now.Add(time.Second)
+	if !l.Allow() || l.Allow() {
+		t.Error("Wait() did not return the token.")
+	}
+
+	// A token which is available is not taken when the context is
+	// already done.
+	now = now.Add(time.Second)
+	if _, err := l.Wait(ctx); err != context.Canceled {
+		t.Errorf("Wait() returned \"%v\"; expected \"%v\".\n", err,
+			context.Canceled)
+	}
+	if !l.Allow() {
+		t.Error("Wait() with a done context took the token.")
+	}
+}
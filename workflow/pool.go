@@ -0,0 +1,129 @@
+package workflow
+
+import (
+	"context"
+	"time"
+)
+
+// Result is the outcome of the execution of a single input by a Pool.
+type Result[In, Out any] struct {
+	// Input which was executed
+	In In
+	// Output of the function, and the error which it returned, if any
+	Out Out
+	Err error
+}
+
+// Pool executes a function on each of a series of inputs, possibly
+// concurrently, delivering the results as they complete.  It is a type-safe
+// layer over a Workflow, which relieves the caller from implementing the Item
+// interface and from asserting the types of the completed Items.
+type Pool[In, Out any] struct {
+	wf *Workflow
+	fn func(context.Context, In) (Out, error)
+}
+
+// poolItem is the Item which represents an input to a Pool, and then its
+// result.
+type poolItem[In, Out any] struct {
+	fn     func(context.Context, In) (Out, error)
+	result Result[In, Out]
+}
+
+// Do executes the function on the input and puts the item, with the result,
+// on the output queue.
+func (item poolItem[In, Out]) Do(ctx context.Context, output chan<- Item) {
+	item.result.Out, item.result.Err = item.fn(ctx, item.result.In)
+	output <- item
+}
+
+// NewPool creates a new Pool, which executes the specified function,
+// specifying the total number of inputs, the maximum number of inputs to be
+// executed concurrently, and the maximum number of inputs to start per
+// second (as for New).  The Pool is cancelled (as by Cancel) when the
+// specified context is done; the context passed to the function is cancelled
+// when the Pool is cancelled or when the input's deadline (if any) passes.
+func NewPool[In, Out any](ctx context.Context, size, maxActors, maxRate int,
+	fn func(context.Context, In) (Out, error)) *Pool[In, Out] {
+	return &Pool[In, Out]{
+		wf: NewContext(ctx, size, maxActors, maxRate),
+		fn: fn,
+	}
+}
+
+// NewStreamPool creates a new streaming Pool, which executes the specified
+// function, specifying the size of its queues, the maximum number of inputs
+// to be executed concurrently, and the maximum number of inputs to start per
+// second (as for NewStream).  Submit blocks while the input queue is full, so
+// the caller must keep calling Next, and, once all of the inputs have been
+// submitted, must call CloseInput.  The context is treated as for NewPool.
+func NewStreamPool[In, Out any](ctx context.Context, buffer, maxActors,
+	maxRate int, fn func(context.Context, In) (Out, error)) *Pool[In, Out] {
+	return &Pool[In, Out]{
+		wf: NewStream(ctx, buffer, maxActors, maxRate),
+		fn: fn,
+	}
+}
+
+// SetItemTimeout sets the time allowed for each input (as for the
+// Workflow's SetItemTimeout).  It must be called before any inputs are
+// submitted.
+func (p *Pool[In, Out]) SetItemTimeout(timeout time.Duration) {
+	p.wf.SetItemTimeout(timeout)
+}
+
+// SetLimiter replaces the limiter used to throttle the rate at which inputs
+// are started (as for the Workflow's SetLimiter).  It must be called before
+// any inputs are submitted.
+func (p *Pool[In, Out]) SetLimiter(limiter *Limiter) {
+	p.wf.SetLimiter(limiter)
+}
+
+// SetController specifies a Controller which limits the number of inputs to
+// be executed concurrently (as for the Workflow's SetController).  It must be
+// called before any inputs are submitted.
+func (p *Pool[In, Out]) SetController(controller *Controller) {
+	p.wf.SetController(controller)
+}
+
+// Submit collects an input to be executed by the Pool, as for the
+// Workflow's Enqueue.
+func (p *Pool[In, Out]) Submit(in In) {
+	p.wf.Enqueue(poolItem[In, Out]{fn: p.fn,
+		result: Result[In, Out]{In: in}})
+}
+
+// CloseInput indicates that no more inputs will be submitted, as for the
+// Workflow's CloseInput.
+func (p *Pool[In, Out]) CloseInput() {
+	p.wf.CloseInput()
+}
+
+// Next returns the result of a completed input; it will block the caller
+// until a result is available to return.  If the Pool has been cancelled or
+// its input has been closed, and there are no more results, Next returns
+// false.
+func (p *Pool[In, Out]) Next() (Result[In, Out], bool) {
+	item, ok := p.wf.Dequeue()
+	if !ok {
+		return Result[In, Out]{}, false
+	}
+	return item.(poolItem[In, Out]).result, true
+}
+
+// Cancel stops the Pool, as for the Workflow's Cancel.
+func (p *Pool[In, Out]) Cancel() {
+	p.wf.Cancel()
+}
+
+// Shutdown cancels the Pool and waits for the inputs which are in progress to
+// complete, as for the Workflow's Shutdown (for a streaming Pool, the results
+// which have not been returned by Next are discarded).
+func (p *Pool[In, Out]) Shutdown(ctx context.Context) error {
+	return p.wf.Shutdown(ctx)
+}
+
+// Destroy destroys the Pool, releasing its resources for garbage collection.
+func (p *Pool[In, Out]) Destroy() {
+	p.wf.Destroy()
+}
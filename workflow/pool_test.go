@@ -0,0 +1,121 @@
+// Unit tests for the Pool of package workflow.
+package workflow
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Test NewPool(), Submit(), and Next().
+func TestPool(t *testing.T) {
+	const itemCount = 10
+
+	p := NewPool(context.Background(), itemCount, 3, 0,
+		func(ctx context.Context, n int) (string, error) {
+			if n%5 == 0 {
+				return "", errors.New("multiple of five")
+			}
+			return strconv.Itoa(n * n), nil
+		})
+	defer p.Destroy()
+
+	for i := 1; i <= itemCount; i++ {
+		p.Submit(i)
+	}
+
+	var got []int
+	for i := 1; i <= itemCount; i++ {
+		r, ok := p.Next()
+		if !ok {
+			t.Fatalf("Next() of result #%d failed.\n", i)
+		}
+		got = append(got, r.In)
+		switch {
+		case r.In%5 == 0 && r.Err == nil:
+			t.Errorf("Input %d returned %q; expected an error.\n",
+				r.In, r.Out)
+		case r.In%5 != 0 && r.Out != strconv.Itoa(r.In*r.In):
+			t.Errorf("Input %d returned %q, \"%v\".\n", r.In,
+				r.Out, r.Err)
+		}
+	}
+
+	// The results may arrive in any order, but each input must produce
+	// exactly one.
+	sort.Ints(got)
+	for i, n := range got {
+		if n != i+1 {
+			t.Errorf("Got results for inputs %v.\n", got)
+			break
+		}
+	}
+}
+
+// Test the cancellation of a Pool and its item timeout.
+func TestPoolCancel(t *testing.T) {
+	started := make(chan bool, 10)
+	wait := func(ctx context.Context, n int) (int, error) {
+		started <- true
+		<-ctx.Done()
+		return n, ctx.Err()
+	}
+
+	p := NewPool(context.Background(), 10, 1, 0, wait)
+	defer p.Destroy()
+	for i := 1; i <= 10; i++ {
+		p.Submit(i)
+	}
+	<-started
+	p.Cancel()
+	if r, ok := p.Next(); !ok || r.In != 1 || r.Err != context.Canceled {
+		t.Errorf("Next() returned %+v, %v.\n", r, ok)
+	}
+	if r, ok := p.Next(); ok {
+		t.Errorf("Next() unexpectedly returned %+v.\n", r)
+	}
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() returned \"%v\".\n", err)
+	}
+
+	p = NewPool(context.Background(), 1, 1, 0, wait)
+	defer p.Destroy()
+	p.SetItemTimeout(10 * time.Millisecond)
+	p.Submit(1)
+	r, ok := p.Next()
+	if !ok || r.Err != context.DeadlineExceeded {
+		t.Errorf("Next() returned %+v, %v.\n", r, ok)
+	}
+}
+
+// Test NewStreamPool() with more inputs than fit in the queues.
+func TestStreamPool(t *testing.T) {
+	const itemCount = 100
+
+	p := NewStreamPool(context.Background(), 1, 4, 0,
+		func(ctx context.Context, n int) (int, error) {
+			return 2 * n, nil
+		})
+	defer p.Destroy()
+	go func() {
+		for i := 1; i <= itemCount; i++ {
+			p.Submit(i)
+		}
+		p.CloseInput()
+	}()
+
+	sum := 0
+	for {
+		r, ok := p.Next()
+		if !ok {
+			break
+		}
+		sum += r.Out
+	}
+	if expected := itemCount * (itemCount + 1); sum != expected {
+		t.Errorf("Got sum %d; expected %d.\n", sum, expected)
+	}
+}
@@ -3,6 +3,8 @@
 package workflow
 
 import (
+	"context"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -12,22 +14,49 @@ import (
 // An Item can be handed off to this package to be executed independently of
 // the caller and other items in the workflow.
 type Item interface {
-	// Do initiates the work on the Item; the result should be sent to the
-	// provided chanel.
-	Do(chan<- Item)
+	// Do performs the work on the Item; the result should be sent to the
+	// provided chanel, exactly once.  The context is cancelled when the
+	// workflow is cancelled or when the Item's deadline (if any) passes,
+	// in which case Do should abandon the work and return promptly (but it
+	// should still send its result).
+	Do(ctx context.Context, output chan<- Item)
 }
 
 // Workflow represents and controls the flow of work.  Multiple independent
 // workflows may be created and active concurrently.
+//
+// A workflow created by New or NewContext executes a predetermined number of
+// Items, and its queues are large enough to hold all of them.  A streaming
+// workflow, created by NewStream, accepts any number of Items, and its queues
+// are of a fixed size:  Enqueue blocks while the input queue is full, and
+// the actors block while the output queue is full, so the caller must keep
+// dequeuing completed Items (or call Shutdown, which discards them).  Once
+// the caller calls CloseInput and all of the Items are complete, the channel
+// returned by Results is closed.
 type Workflow struct {
 	// Queues of pending and completed work items
 	input, output chan Item
-	// Interval between starting work items for rate throttling
-	interval time.Duration
-	// Timer used for rate throttling
-	throttle *time.Timer
-	// Number of completed work items
-	done int32
+	// Closes the input queue (only once)
+	closeInput sync.Once
+	// Whether the workflow is streaming (and closes its output queue when
+	// the actors have exited)
+	streaming bool
+	// Context governing the workflow, and the function which cancels it
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Time allowed for each work item (0: no limit)
+	itemTimeout time.Duration
+	// Limiter used for rate throttling (nil:  no limit)
+	limiter *Limiter
+	// Controller used to adapt the concurrency, if any
+	controller *Controller
+	// The running actors, and a channel which is closed once they have
+	// all exited
+	actors   sync.WaitGroup
+	finished chan struct{}
+	// Number of completed work items, and the number of those which have
+	// been dequeued (the latter only by Dequeue)
+	done, dequeued int32
 	// Number of work items initiated without throttling
 	unthrottled int32
 }
@@ -36,78 +65,248 @@ type Workflow struct {
 // maximum number of Items to be executed concurrently, and the maximum number
 // of Items to start per second.
 func New(size, maxActors, maxRate int) *Workflow {
+	return NewContext(context.Background(), size, maxActors, maxRate)
+}
+
+// NewContext creates a new Workflow, as by New, which is cancelled (as by
+// Cancel) when the specified context is done.
+func NewContext(ctx context.Context, size, maxActors, maxRate int) *Workflow {
+	return newWorkflow(ctx, size, maxActors, maxRate, false)
+}
+
+// NewStream creates a new streaming Workflow, which is cancelled (as by
+// Cancel) when the specified context is done, specifying the size of its
+// queues, the maximum number of Items to be executed concurrently, and the
+// maximum number of Items to start per second.
+func NewStream(ctx context.Context, buffer, maxActors,
+	maxRate int) *Workflow {
+	return newWorkflow(ctx, buffer, maxActors, maxRate, true)
+}
+
+// newWorkflow creates a new Workflow with queues of the specified size.
+func newWorkflow(ctx context.Context, size, maxActors, maxRate int,
+	streaming bool) *Workflow {
 	wf := new(Workflow)
 	wf.input = make(chan Item, size)
 	wf.output = make(chan Item, size)
-	if maxRate != 0 {
-		wf.interval = time.Second / time.Duration(maxRate)
-		wf.throttle = time.NewTimer(wf.interval)
-	}
+	wf.streaming = streaming
+	wf.ctx, wf.cancel = context.WithCancel(ctx)
+	wf.limiter = NewLimiter(maxRate, 1)
+	wf.finished = make(chan struct{})
+	wf.actors.Add(maxActors)
 	for i := 0; i < maxActors; i++ {
-		go wf.act()
+		go func() {
+			defer wf.actors.Done()
+			wf.act()
+		}()
 	}
+	go func() {
+		wf.actors.Wait()
+		close(wf.finished)
+		if wf.streaming {
+			close(wf.output)
+		}
+	}()
 	return wf
 }
 
-// Destroy destroys the workflow, releasing its resources for garbage
-// collection.
-func (wf Workflow) Destroy() {
-	close(wf.input)
-	close(wf.output)
+// SetItemTimeout sets the time allowed for each Item, after which the context
+// passed to its Do method is cancelled (0: no limit).  It must be called
+// before any Items are enqueued.
+func (wf *Workflow) SetItemTimeout(timeout time.Duration) {
+	wf.itemTimeout = timeout
+}
+
+// SetLimiter replaces the limiter used to throttle the rate at which Items are
+// started (e.g., with one which allows bursts); nil imposes no limit.  It must
+// be called before any Items are enqueued.
+func (wf *Workflow) SetLimiter(limiter *Limiter) {
+	wf.limiter = limiter
+}
+
+// SetController specifies a Controller which limits the number of Items to be
+// executed concurrently (within the maximum number of actors); the caller is
+// responsible for reporting the outcomes of the Items to it.  It must be
+// called before any Items are enqueued.
+func (wf *Workflow) SetController(controller *Controller) {
+	wf.controller = controller
+}
+
+// Cancel stops the workflow:  no further Items are started, and the contexts
+// of the Items which are in progress are cancelled.  Items which were not
+// started (or which are enqueued later) are discarded, and, once the Items in
+// progress are complete, Dequeue reports that there are no more.
+func (wf *Workflow) Cancel() {
+	wf.cancel()
+}
+
+// Shutdown cancels the workflow (as by Cancel) and waits for the Items which
+// are in progress to complete.  For a streaming workflow, the completed Items
+// which have not been dequeued are discarded meanwhile, so that actors which
+// are blocked on a full output queue can exit.  If the specified context is
+// done first, Shutdown returns its error, leaving those Items running.
+func (wf *Workflow) Shutdown(ctx context.Context) error {
+	wf.Cancel()
+	var discard chan Item // Receiving from nil blocks forever.
+	if wf.streaming {
+		discard = wf.output
+	}
+	for {
+		select {
+		case <-wf.finished:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-discard:
+			if !ok {
+				discard = nil
+			}
+		}
+	}
+}
+
+// Destroy destroys the workflow, cancelling it (as by Cancel) and releasing
+// its resources for garbage collection.  (The input queue is left open, so
+// that a concurrent Enqueue discards its Item rather than failing.)
+func (wf *Workflow) Destroy() {
+	wf.Cancel()
 	vdiag.Out(3, "Performed %d operations, %d without throttling.\n",
-		wf.done, wf.unthrottled)
+		atomic.LoadInt32(&wf.done), atomic.LoadInt32(&wf.unthrottled))
 }
 
 // Act pulls work items from the input queue and executes them until the flow
-// is complete.
+// is complete or cancelled.
 func (wf *Workflow) act() {
 	for {
-		t := int32(1) // For the unthrottled & no-throttle cases
-		if wf.throttle != nil {
-			select {
-			case <-wf.throttle.C:
-				// We were able to receive without
-				// blocking, so we were not throttled.
-			default:
-				// We are being throttled -- wait for
-				// our turn
-				t = 0
-				<-wf.throttle.C
+		// Get an item from the input queue; if the input queue is
+		// closed or the flow is cancelled, exit.
+		var item Item
+		select {
+		case i, ok := <-wf.input:
+			if !ok {
+				return
 			}
-
-			// Request a new interval timer for the next actor.
-			wf.throttle = time.NewTimer(wf.interval)
+			item = i
+		case <-wf.ctx.Done():
+			return
 		}
 
-		// Get an item from the input queue and execute it (which
-		// should queue it to the output queue); if the input queue is
-		// closed, exit.
-		item, ok := <-wf.input
-		if !ok {
+		// If the concurrency is being limited or we are being
+		// throttled, wait for our turn, and then execute the item
+		// (which should queue it to the output queue).
+		if wf.controller != nil {
+			if wf.controller.Acquire(wf.ctx) != nil {
+				return
+			}
+		}
+		throttled, err := wf.limiter.Wait(wf.ctx)
+		if err != nil {
+			wf.release()
 			return
 		}
-		item.Do(wf.output)
+		t := int32(1) // For the unthrottled & no-throttle cases
+		if throttled {
+			t = 0
+		}
+		wf.do(item)
+		wf.release()
 		atomic.AddInt32(&wf.done, 1)
 		atomic.AddInt32(&wf.unthrottled, t)
 	}
 }
 
-// Enqueue collects Items to be executed in the specified workflow.
-func (wf Workflow) Enqueue(item Item) {
-	wf.input <- item
+// release releases the item's slot in the concurrency limit, if any.
+func (wf *Workflow) release() {
+	if wf.controller != nil {
+		wf.controller.Release()
+	}
+}
+
+// do executes a work item, subject to the item timeout, if any.
+func (wf *Workflow) do(item Item) {
+	ctx := wf.ctx
+	if wf.itemTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wf.itemTimeout)
+		defer cancel()
+	}
+	item.Do(ctx, wf.output)
+}
+
+// Enqueue collects Items to be executed in the specified workflow; it will
+// block the caller while the input queue is full.  If the workflow has been
+// cancelled, the Item is discarded.  Enqueue must not be called after
+// CloseInput.
+func (wf *Workflow) Enqueue(item Item) {
+	select {
+	case wf.input <- item:
+	case <-wf.ctx.Done():
+	}
+}
+
+// CloseInput indicates that no more Items will be enqueued:  once the Items
+// which were enqueued are complete, the actors exit, Dequeue reports that
+// there are no more Items, and, for a streaming workflow, the channel
+// returned by Results is closed.
+func (wf *Workflow) CloseInput() {
+	wf.closeInput.Do(func() {
+		close(wf.input)
+	})
 }
 
 // Dequeue returns a completed Item from the specified workflow; it will
-// block the caller until an Item is available to return.
-func (wf Workflow) Dequeue() Item {
-	return <-wf.output
+// block the caller until an Item is available to return.  If the workflow
+// has been cancelled or its input has been closed, and there are no more
+// completed Items, Dequeue returns false.
+func (wf *Workflow) Dequeue() (Item, bool) {
+	select {
+	case item, ok := <-wf.output:
+		if !ok {
+			return nil, false
+		}
+		atomic.AddInt32(&wf.dequeued, 1)
+		return item, true
+	case <-wf.finished:
+	}
+
+	// The actors have exited, but items which they completed may remain.
+	select {
+	case item, ok := <-wf.output:
+		if !ok {
+			return nil, false
+		}
+		atomic.AddInt32(&wf.dequeued, 1)
+		return item, true
+	default:
+		return nil, false
+	}
+}
+
+// Results returns the channel on which the completed Items of a streaming
+// workflow are delivered (as an alternative to Dequeue); the channel is
+// closed once the input has been closed (or the workflow cancelled) and the
+// Items in progress are complete.
+func (wf *Workflow) Results() <-chan Item {
+	return wf.output
 }
 
-// Wait causes the caller to block until all of the workflow Items are complete.
+// Wait causes the caller to block until all of the workflow Items are
+// complete (or the workflow is cancelled).  For a streaming workflow, this
+// requires that the input be closed.
 func (wf *Workflow) Wait() {
+	if wf.streaming {
+		for {
+			if _, ok := wf.Dequeue(); !ok {
+				return
+			}
+		}
+	}
+
 	// As long as there is pending input items or active executions,
 	// wait for completions.
-	for wf.done < int32(cap(wf.output)) {
-		<-wf.output
+	for atomic.LoadInt32(&wf.dequeued) < int32(cap(wf.output)) {
+		if _, ok := wf.Dequeue(); !ok {
+			return
+		}
 	}
 }
@@ -7,7 +7,9 @@
 package workflow
 
 import (
+	"context"
 	"testing"
+	"time"
 )
 
 // Struct testItem implements workflow.Item
@@ -17,7 +19,7 @@ type testItem struct {
 }
 
 // Do marks the item as done and puts it on the output queue
-func (item testItem) Do(output chan<- Item) {
+func (item testItem) Do(ctx context.Context, output chan<- Item) {
 	item.done = true
 	output <- item
 }
@@ -47,8 +49,8 @@ func TestNew(t *testing.T) {
 			cap(wf.output), expectedSize)
 	}
 
-	if wf.interval != 0 {
-		t.Errorf("Rate is %v; expected zero\n", wf.interval)
+	if wf.limiter != nil {
+		t.Error("Rate is limited; expected unlimited.")
 	}
 }
 
@@ -67,7 +69,11 @@ func TestFlowSerial(t *testing.T) {
 	wf.act()
 
 	for i := 1; i <= itemCount; i++ {
-		item := wf.Dequeue().(testItem)
+		v, ok := wf.Dequeue()
+		if !ok {
+			t.Fatalf("Dequeue() of item #%d failed.\n", i)
+		}
+		item := v.(testItem)
 		if !item.done {
 			t.Errorf("Item #%d was completed but not marked done.\n",
 				i)
@@ -96,7 +102,11 @@ func TestFlowConcurrent(t *testing.T) {
 	}
 
 	for i := 1; i <= itemCount; i++ {
-		item := wf.Dequeue().(testItem)
+		v, ok := wf.Dequeue()
+		if !ok {
+			t.Fatalf("Dequeue() of item #%d failed.\n", i)
+		}
+		item := v.(testItem)
 		if !item.done {
 			t.Errorf("Item #%d was completed but not marked done.\n",
 				i)
@@ -107,6 +117,8 @@ func TestFlowConcurrent(t *testing.T) {
 		}
 	}
 
+	// Stop the actor, so that it is done counting.
+	wf.Shutdown(context.Background())
 	if wf.done != itemCount {
 		t.Errorf("Done is %d; expected %d.\n", wf.done, itemCount)
 	}
@@ -124,7 +136,231 @@ func TestWait(t *testing.T) {
 
 	wf.Wait()
 
+	wf.Shutdown(context.Background())
 	if wf.done != itemCount {
 		t.Errorf("Done is %d; expected %d.\n", wf.done, itemCount)
 	}
 }
+
+// Struct blockingItem implements workflow.Item, blocking until its context is
+// done (or, if it is stubborn, until it is released).
+type blockingItem struct {
+	started  chan<- bool
+	release  <-chan bool
+	stubborn bool
+	err      error
+}
+
+// Do waits and puts the item, with the reason for stopping, on the output
+// queue.
+func (item blockingItem) Do(ctx context.Context, output chan<- Item) {
+	item.started <- true
+	if item.stubborn {
+		<-item.release
+	} else {
+		<-ctx.Done()
+		item.err = ctx.Err()
+	}
+	output <- item
+}
+
+// Test Cancel() and the contract of Dequeue() after cancellation.
+func TestCancel(t *testing.T) {
+	const itemCount = 10
+
+	started := make(chan bool, itemCount)
+	wf := New(itemCount, 2, 0)
+	defer wf.Destroy()
+	for i := 1; i <= itemCount; i++ {
+		wf.Enqueue(blockingItem{started: started})
+	}
+	<-started
+	<-started
+	wf.Cancel()
+
+	// The two items in progress complete, and the others are discarded.
+	for i := 1; i <= 2; i++ {
+		v, ok := wf.Dequeue()
+		if !ok {
+			t.Fatalf("Dequeue() of item #%d failed.\n", i)
+		}
+		if err := v.(blockingItem).err; err != context.Canceled {
+			t.Errorf("Item #%d stopped with \"%v\"; expected "+
+				"\"%v\".\n", i, err, context.Canceled)
+		}
+	}
+	if v, ok := wf.Dequeue(); ok {
+		t.Errorf("Dequeue() unexpectedly returned %+v.\n", v)
+	}
+	if len(started) != 0 {
+		t.Errorf("%d items were started after the cancellation.\n",
+			len(started))
+	}
+}
+
+// Test NewContext() with a context which is cancelled.
+func TestNewContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan bool, 1)
+	wf := NewContext(ctx, 1, 1, 0)
+	defer wf.Destroy()
+	wf.Enqueue(blockingItem{started: started})
+	<-started
+	cancel()
+
+	v, ok := wf.Dequeue()
+	if !ok || v.(blockingItem).err != context.Canceled {
+		t.Errorf("Dequeue() returned %+v, %v.\n", v, ok)
+	}
+}
+
+// Test SetItemTimeout()
+func TestItemTimeout(t *testing.T) {
+	started := make(chan bool, 1)
+	wf := New(1, 1, 0)
+	defer wf.Destroy()
+	wf.SetItemTimeout(10 * time.Millisecond)
+	wf.Enqueue(blockingItem{started: started})
+
+	v, ok := wf.Dequeue()
+	if !ok || v.(blockingItem).err != context.DeadlineExceeded {
+		t.Errorf("Dequeue() returned %+v, %v.\n", v, ok)
+	}
+}
+
+// Test Shutdown(), both with an item which stops when cancelled and with one
+// which doesn't.
+func TestShutdown(t *testing.T) {
+	for _, stubborn := range []bool{false, true} {
+		started := make(chan bool, 1)
+		release := make(chan bool)
+		wf := New(1, 1, 0)
+		wf.Enqueue(blockingItem{started: started, release: release,
+			stubborn: stubborn})
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(),
+			10*time.Millisecond)
+		err := wf.Shutdown(ctx)
+		cancel()
+		switch {
+		case !stubborn && err != nil:
+			t.Errorf("Shutdown() returned \"%v\".\n", err)
+		case stubborn && err != context.DeadlineExceeded:
+			t.Errorf("Shutdown() of a stubborn item returned "+
+				"\"%v\"; expected \"%v\".\n", err,
+				context.DeadlineExceeded)
+		}
+		close(release)
+		wf.Destroy()
+	}
+}
+
+// Test NewStream(), CloseInput(), and Results(), with more Items than fit in
+// the queues.
+func TestStream(t *testing.T) {
+	const itemCount = 100
+
+	wf := NewStream(context.Background(), 2, 3, 0)
+	defer wf.Destroy()
+	if cap(wf.input) != 2 || cap(wf.output) != 2 {
+		t.Errorf("Queue sizes are %d and %d; expected 2.\n",
+			cap(wf.input), cap(wf.output))
+	}
+
+	go func() {
+		for i := 1; i <= itemCount; i++ {
+			wf.Enqueue(testItem{id: i})
+		}
+		wf.CloseInput()
+	}()
+
+	seen := make(map[int]bool)
+	for v := range wf.Results() {
+		item := v.(testItem)
+		if !item.done || seen[item.id] {
+			t.Errorf("Got unexpected item %+v.\n", item)
+		}
+		seen[item.id] = true
+	}
+	if len(seen) != itemCount {
+		t.Errorf("Got %d items; expected %d.\n", len(seen), itemCount)
+	}
+	if v, ok := wf.Dequeue(); ok {
+		t.Errorf("Dequeue() unexpectedly returned %+v.\n", v)
+	}
+}
+
+// Test that Enqueue() applies backpressure to a streaming workflow, and that
+// cancellation releases it.
+func TestStreamBackpressure(t *testing.T) {
+	started := make(chan bool, 10)
+	wf := NewStream(context.Background(), 1, 1, 0)
+	defer wf.Destroy()
+
+	// One item is in progress and one is queued, so the third blocks.
+	enqueued := make(chan int, 3)
+	go func() {
+		for i := 1; i <= 3; i++ {
+			wf.Enqueue(blockingItem{started: started})
+			enqueued <- i
+		}
+	}()
+	<-started
+	<-enqueued
+	<-enqueued
+	select {
+	case <-enqueued:
+		t.Error("Enqueue() did not block with a full queue.")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	wf.Cancel()
+	<-enqueued
+	wf.Wait()
+	if len(started) != 0 {
+		t.Errorf("%d items were started after the cancellation.\n",
+			len(started))
+	}
+}
+
+// Test that Shutdown() of a streaming workflow returns even though the
+// completed Items are not being dequeued and the output queue is full.
+func TestStreamShutdown(t *testing.T) {
+	wf := NewStream(context.Background(), 1, 2, 0)
+	defer wf.Destroy()
+
+	// One item fills the output queue, two actors block trying to add
+	// theirs, and the last item waits in the input queue.
+	for i := 1; i <= 4; i++ {
+		wf.Enqueue(testItem{id: i})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := wf.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() returned \"%v\".\n", err)
+	}
+	if _, ok := wf.Dequeue(); ok {
+		t.Error("Dequeue() after Shutdown() returned an item.")
+	}
+}
+
+// Test CloseInput() for a workflow of predetermined size.
+func TestCloseInput(t *testing.T) {
+	wf := New(10, 2, 0)
+	defer wf.Destroy()
+	for i := 1; i <= 3; i++ {
+		wf.Enqueue(testItem{id: i})
+	}
+	wf.CloseInput()
+
+	for i := 1; i <= 3; i++ {
+		if _, ok := wf.Dequeue(); !ok {
+			t.Fatalf("Dequeue() of item #%d failed.\n", i)
+		}
+	}
+	if v, ok := wf.Dequeue(); ok {
+		t.Errorf("Dequeue() unexpectedly returned %+v.\n", v)
+	}
+}